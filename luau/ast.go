@@ -58,24 +58,31 @@ type IfStmt struct {
 
 func (i *IfStmt) Render(w Writer) {
 	w.Pre("if ")
+	i.branches(w)
+	w.Pre("end\n")
+}
+
+// render condition, body and the else branches
+func (i *IfStmt) branches(w Writer) {
 	i.Cond.Render(w)
 	w.Write(" then\n")
 
 	i.Body.Render(w)
 
-	if elseif, ok := i.Else.(*IfStmt); ok {
+	switch e := i.Else.(type) {
+	case nil:
+	case *IfStmt:
 		w.Pre("elseif ")
-		elseif.Cond.Render(w)
-		w.Write(" then\n")
-		elseif.Body.Render(w)
-		w.Pre("else")
-		elseif.Else.Render(w)
-	} else {
+		e.branches(w)
+	case *DoStmt:
+		w.Pre("else\n")
+		e.Chunk.Render(w)
+	default:
 		w.Pre("else\n")
-		i.Else.Render(w)
+		w.IncIndent()
+		e.Render(w)
+		w.DecIndent()
 	}
-
-	w.Pre("end\n")
 }
 
 // Do-end Chunk
@@ -100,7 +107,7 @@ type WhileStmt struct {
 func (wh *WhileStmt) Render(w Writer) {
 	w.Pre("while ")
 	wh.Exp.Render(w)
-	w.Write("do\n")
+	w.Write(" do\n")
 
 	wh.Chunk.Render(w)
 	w.Pre("end\n")
@@ -149,7 +156,7 @@ func (g *GenericForStmt) Render(w Writer) {
 	g.Iter.Render(w)
 	w.Write(" do\n")
 	g.Chunk.Render(w)
-	w.Pre("end")
+	w.Pre("end\n")
 }
 
 // Var declaration
@@ -161,8 +168,9 @@ type DeclStmt struct {
 }
 
 func (v *DeclStmt) Render(w Writer) {
+	w.Pre("")
 	if v.Scope == LOCAL {
-		w.Pre("local ")
+		w.Write("local ")
 	}
 	for i, n := range v.Names {
 		n.Render(w)
@@ -170,6 +178,13 @@ func (v *DeclStmt) Render(w Writer) {
 			w.Write(",")
 		}
 	}
+
+	// forward declaration
+	if len(v.Values) == 0 {
+		w.Write("\n")
+		return
+	}
+
 	w.Write(" = ")
 	for i, n := range v.Values {
 		n.Render(w)
@@ -188,6 +203,7 @@ type AssignStmt struct {
 }
 
 func (a *AssignStmt) Render(w Writer) {
+	w.Pre("")
 	for i, p := range a.Left {
 		p.Render(w)
 		if i != len(a.Left)-1 {
@@ -204,6 +220,22 @@ func (a *AssignStmt) Render(w Writer) {
 	w.Write("\n")
 }
 
+// Compound assignment statement
+// ex: a += 5
+type CompoundAssignStmt struct {
+	Left  Node
+	Right Node
+	Op    Token
+}
+
+func (c *CompoundAssignStmt) Render(w Writer) {
+	w.Pre("")
+	c.Left.Render(w)
+	w.Write(" " + FormatToken(c.Op) + " ")
+	c.Right.Render(w)
+	w.Write("\n")
+}
+
 // Expression statement
 // ex: print("hello")
 type ExprStmt struct {
@@ -235,6 +267,7 @@ func (r *ReturnStmt) Render(w Writer) {
 
 // Function declaraion
 // ex: function foo() end
+// with NONE scope it assigns to a forward declared local
 type FuncStmt struct {
 	Name   *Ident
 	Params []*Ident
@@ -243,8 +276,9 @@ type FuncStmt struct {
 }
 
 func (f *FuncStmt) Render(w Writer) {
+	w.Pre("")
 	if f.Scope == LOCAL {
-		w.Pre("local ")
+		w.Write("local ")
 	}
 
 	w.Write("function ")
@@ -261,7 +295,7 @@ func (f *FuncStmt) Render(w Writer) {
 	w.Write(")\n")
 
 	f.Chunk.Render(w)
-	w.Write("end\n")
+	w.Pre("end\n")
 }
 
 // Function literal
@@ -273,19 +307,18 @@ type FuncLit struct {
 }
 
 func (f *FuncLit) Render(w Writer) {
-	w.Pre("function ")
-	w.Write("(")
+	w.Write("function(")
 
 	for i, p := range f.Params {
 		p.Render(w)
-		if i != len(f.Params) {
+		if i != len(f.Params)-1 {
 			w.Write(",")
 		}
 	}
 	w.Write(")\n")
 
 	f.Chunk.Render(w)
-	w.Write("end\n")
+	w.Pre("end")
 }
 
 // Numeric literal
@@ -406,10 +439,25 @@ type BinaryExpr struct {
 
 func (b *BinaryExpr) Render(w Writer) {
 	b.Left.Render(w)
-	w.Write(FormatToken(b.Op))
+	w.Write(" " + FormatToken(b.Op) + " ")
 	b.Right.Render(w)
 }
 
+// Unary expression
+// ex: not true
+type UnaryExpr struct {
+	X  Node
+	Op Token
+}
+
+func (u *UnaryExpr) Render(w Writer) {
+	w.Write(FormatToken(u.Op))
+	if u.Op == NOT {
+		w.Write(" ")
+	}
+	u.X.Render(w)
+}
+
 // Parenthesized expression
 // ex: (2 + 2) * 2
 type ParenExpr struct {
@@ -1,5 +1,7 @@
 package luau
 
+import "strings"
+
 // ==================================
 // Base node
 type Node interface {
@@ -27,43 +29,48 @@ type File struct {
 	Out     string // render destination
 }
 
+// Raw nodes are rendered first, then the declarations sorted by their dependencies
+// and lastly the rest of nodes (e.g. the export table) in their order
 func (f *File) Render(w Writer) {
-	/*
-		// decl order: raw -> decl -> func -> [the rest]
-		rendered := []int{}
-
-		for i, v := range f.Decls {
-			if _, ok := v.(*Raw); ok {
-				v.Render(w)
-				rendered = append(rendered, i)
-			}
+	decls := []*Decl{}
+	rest := []Node{}
+	for _, v := range f.Decls {
+		switch d := v.(type) {
+		case *Raw:
+			v.Render(w)
+		case *Decl:
+			decls = append(decls, d)
+		default:
+			rest = append(rest, v)
 		}
+	}
 
-		for i, v := range f.Decls {
-			if _, ok := v.(*DeclStmt); ok {
-				v.Render(w)
-				rendered = append(rendered, i)
-			}
+	sorted := SortDecls(decls)
+	forward := Forwarded(sorted)
+	if len(forward) > 0 {
+		names := make([]Node, len(forward))
+		for i, n := range forward {
+			names[i] = &Ident{Name: n}
 		}
+		(&DeclStmt{Scope: LOCAL, Names: names}).Render(w)
+	}
 
-		for i, v := range f.Decls {
-			if _, ok := v.(*FuncStmt); ok {
-				v.Render(w)
-				rendered = append(rendered, i)
-			}
-		}
+	fwd := map[string]bool{}
+	for _, n := range forward {
+		fwd[n] = true
+	}
 
-		for i, v := range f.Decls {
-			if !slices.Contains(rendered, i) {
-				v.Render(w)
-			}
+	for _, d := range sorted {
+		if len(d.Names) > 0 && fwd[d.Names[0]] {
+			assignment(d.Node).Render(w)
+		} else {
+			d.Render(w)
 		}
-	*/
+	}
 
-	for _, v := range f.Decls {
+	for _, v := range rest {
 		v.Render(w)
 	}
-
 }
 
 func NewFile(name string, out string) *File {
@@ -75,13 +82,118 @@ func NewFile(name string, out string) *File {
 	}
 }
 
+// ==================================
+// Decl is a top-level declaration of a file,
+// Names are the names it declares and Deps are the top-level names it refers to.
+// Dotted names (methods) are never declared as locals
+type Decl struct {
+	Node  Node
+	Names []string
+	Deps  []string
+}
+
+func (d *Decl) Render(w Writer) {
+	d.Node.Render(w)
+}
+
+// SortDecls orders declarations so that each one follows its dependencies,
+// otherwise keeping their order. Members of a cycle follow whatever is reached first
+func SortDecls(decls []*Decl) []*Decl {
+	index := map[string]*Decl{}
+	for _, d := range decls {
+		for _, n := range d.Names {
+			index[n] = d
+		}
+	}
+
+	res := []*Decl{}
+	visited := map[*Decl]bool{}
+	var visit func(d *Decl)
+	visit = func(d *Decl) {
+		if visited[d] {
+			return
+		}
+		visited[d] = true
+		for _, dep := range d.Deps {
+			if dd, ok := index[dep]; ok {
+				visit(dd)
+			}
+		}
+		res = append(res, d)
+	}
+
+	for _, d := range decls {
+		visit(d)
+	}
+	return res
+}
+
+// Forwarded lists the names of sorted declarations which are referred to before being declared.
+// A declaration has either all or none of its names forwarded
+func Forwarded(sorted []*Decl) []string {
+	index := map[string]*Decl{}
+	for _, d := range sorted {
+		for _, n := range d.Names {
+			index[n] = d
+		}
+	}
+
+	fwd := map[*Decl]bool{}
+	declared := map[string]bool{}
+	for _, d := range sorted {
+		for _, dep := range d.Deps {
+			dd, ok := index[dep]
+			if ok && dd != d && !declared[dep] {
+				fwd[dd] = true
+			}
+		}
+		for _, n := range d.Names {
+			declared[n] = true
+		}
+	}
+
+	res := []string{}
+	for _, d := range sorted {
+		if !fwd[d] {
+			continue
+		}
+		for _, n := range d.Names {
+			if !strings.Contains(n, ".") {
+				res = append(res, n)
+			}
+		}
+	}
+	return res
+}
+
+// declaration turned into an assignment to forward declared names
+func assignment(n Node) Node {
+	switch d := n.(type) {
+	case *DeclStmt:
+		c := *d
+		c.Scope = NONE
+		return &c
+	case *FuncStmt:
+		c := *d
+		c.Scope = NONE
+		return &c
+	case *Block:
+		list := make([]Node, len(d.List))
+		for i, v := range d.List {
+			list[i] = assignment(v)
+		}
+		return &Block{List: list}
+	}
+	return n
+}
+
 // ==================================
 type Scope int
 
 const (
 	GLOBAL Scope = iota
 	LOCAL
-	NONE
+	NONE // no declaration, assignment to an existing name
 )
 
 // ==================================
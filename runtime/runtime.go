@@ -0,0 +1,10 @@
+// Package runtime embeds the Luau runtime required by every generated module
+package runtime
+
+import "embed"
+
+// Main is the runtime module, rendered as the init.luau of the runtime folder
+const Main = "go.luau"
+
+//go:embed go.luau std
+var Files embed.FS
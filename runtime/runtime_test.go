@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// luau interpreter running the tests of the runtime, they're skipped without one
+func interpreter(t *testing.T) string {
+	t.Helper()
+	p, err := exec.LookPath("luau")
+	if err != nil {
+		t.Skip("luau is not installed")
+	}
+	return p
+}
+
+// Luau long string holding src as it is
+func longString(src string) string {
+	eq := ""
+	for strings.Contains(src, "]"+eq+"]") {
+		eq += "="
+	}
+	return "[" + eq + "[\n" + src + "]" + eq + "]"
+}
+
+// script defines the runtime in the fake engine of the prelude and runs the test after it.
+// Modules are defined at the folders they're rendered into, the runtime itself at GO
+func script(test string) (string, error) {
+	prelude, err := os.ReadFile(filepath.Join("testdata", "prelude.luau"))
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.Write(prelude)
+	err = fs.WalkDir(Files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		src, err := Files.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		module := path.Join("GO", path.Dir(name))
+		fmt.Fprintf(&b, "define(%q, %s)\n", module, longString(string(src)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	b.WriteString("local GO = require(out.GO)\n")
+	b.WriteString(test)
+	b.WriteString("\nrunTests()\n")
+	return b.String(), nil
+}
+
+func TestRuntime(t *testing.T) {
+	luau := interpreter(t)
+
+	tests, err := filepath.Glob(filepath.Join("testdata", "*.luau"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range tests {
+		if filepath.Base(name) == "prelude.luau" {
+			continue
+		}
+
+		t.Run(strings.TrimSuffix(filepath.Base(name), ".luau"), func(t *testing.T) {
+			test, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, err := script(string(test))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := filepath.Join(t.TempDir(), "main.luau")
+			if err := os.WriteFile(p, []byte(src), 0600); err != nil {
+				t.Fatal(err)
+			}
+			out, err := exec.Command(luau, p).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+		})
+	}
+}
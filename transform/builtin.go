@@ -1,7 +1,178 @@
 package transform
 
-import "github.com/intervinn/abq/luau"
+import (
+	"errors"
+	"go/ast"
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// function of the GO runtime table
+func runtimeFunc(name string) *luau.SelectorExpr {
+	return &luau.SelectorExpr{
+		X:   &luau.Ident{Name: "GO"},
+		Sel: &luau.Ident{Name: name},
+	}
+}
 
 func MacroCallExpr(c *luau.CallExpr) (luau.Node, bool) {
+	if id, ok := c.Fun.(*luau.Ident); ok {
+		switch id.Name {
+		case "panic":
+			return &luau.CallExpr{Fun: runtimeFunc("panic"), Args: c.Args}, true
+		case "recover":
+			return &luau.CallExpr{Fun: runtimeFunc("recover"), Args: []luau.Node{}}, true
+		}
+	}
 	return nil, false
 }
+
+// BuiltinCallExpr transforms calls of Go's builtin functions
+// which depend on the types of their arguments
+func BuiltinCallExpr(name string, c *ast.CallExpr, f *File) (luau.Node, bool, error) {
+	// len of arrays and constants, min and max of constants
+	if tv, ok := f.Pkg.Info.Types[c]; ok && tv.Value != nil {
+		if n, ok := constLit(tv.Value); ok {
+			return n, true, nil
+		}
+	}
+
+	switch name {
+	case "len", "cap":
+		x, err := Expr(c.Args[0], f)
+		if err != nil {
+			return nil, true, err
+		}
+		if name == "len" && isString(f.typeOf(c.Args[0])) {
+			return &luau.UnaryExpr{Op: luau.LEN, X: x}, true, nil
+		}
+		return runtimeCall(name, x), true, nil
+	case "append":
+		if c.Ellipsis.IsValid() {
+			args, err := exprs(c.Args, f)
+			if err != nil {
+				return nil, true, err
+			}
+			return runtimeCall("appendslice", args...), true, nil
+		}
+
+		s, err := Expr(c.Args[0], f)
+		if err != nil {
+			return nil, true, err
+		}
+		var et types.Type
+		if u, ok := underlying(f.typeOf(c.Args[0])).(*types.Slice); ok {
+			et = u.Elem()
+		}
+		args := []luau.Node{s}
+		for _, e := range c.Args[1:] {
+			v, err := ExprAs(e, et, f)
+			if err != nil {
+				return nil, true, err
+			}
+			args = append(args, v)
+		}
+		return runtimeCall("append", args...), true, nil
+	case "copy", "delete", "close":
+		args, err := exprs(c.Args, f)
+		if err != nil {
+			return nil, true, err
+		}
+		if m, ok := underlying(f.typeOf(c.Args[0])).(*types.Map); ok && name == "delete" {
+			if args[1], err = MapKey(c.Args[1], m.Key(), f); err != nil {
+				return nil, true, err
+			}
+		}
+		return runtimeCall(name, args...), true, nil
+	case "clear":
+		x, err := Expr(c.Args[0], f)
+		if err != nil {
+			return nil, true, err
+		}
+		if s, ok := underlying(f.typeOf(c.Args[0])).(*types.Slice); ok {
+			return runtimeCall("clear", x, zeroFunc(s.Elem(), f)), true, nil
+		}
+		return runtimeCall("clear", x), true, nil
+	case "make":
+		return makeCall(c, f)
+	case "min", "max":
+		args, err := exprs(c.Args, f)
+		if err != nil {
+			return nil, true, err
+		}
+		if t := f.typeOf(c); t != nil && isNumeric(t) {
+			return &luau.CallExpr{
+				Fun:  &luau.SelectorExpr{X: &luau.Ident{Name: "math"}, Sel: &luau.Ident{Name: name}},
+				Args: args,
+			}, true, nil
+		}
+		return runtimeCall(name, args...), true, nil
+	case "new":
+		t := f.typeOf(c.Args[0])
+		if t == nil {
+			return nil, false, nil
+		}
+
+		if isAggregate(t) {
+			return zero(t, f), true, nil
+		}
+		return box(zero(t, f), t, f), true, nil
+	}
+	return nil, false, nil
+}
+
+// ex: make([]int, n) -> GO.makeslice(n, nil, 0)
+func makeCall(c *ast.CallExpr, f *File) (luau.Node, bool, error) {
+	t := f.typeOf(c.Args[0])
+	if t == nil {
+		return nil, false, nil
+	}
+
+	args, err := exprs(c.Args[1:], f)
+	if err != nil {
+		return nil, true, err
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		for len(args) < 2 {
+			args = append(args, &luau.Ident{Name: "nil"})
+		}
+		return runtimeCall("makeslice", append(args, zeroFunc(u.Elem(), f))...), true, nil
+	case *types.Map:
+		return &luau.TableLit{Elts: []luau.Node{}}, true, nil
+	case *types.Chan:
+		return runtimeCall("makechan", args...), true, nil
+	}
+	return nil, true, errors.New("make: unsupported type " + t.String())
+}
+
+// zero value of slice elements, structs and arrays are made by a function
+// since every element needs its own table
+func zeroFunc(t types.Type, f *File) luau.Node {
+	z := zero(t, f)
+	if !isAggregate(t) {
+		return z
+	}
+	return &luau.FuncLit{
+		Params: []*luau.Ident{},
+		Chunk:  &luau.Chunk{List: []luau.Node{&luau.ReturnStmt{Results: []luau.Node{z}}}},
+	}
+}
+
+func runtimeCall(name string, args ...luau.Node) *luau.CallExpr {
+	return &luau.CallExpr{Fun: runtimeFunc(name), Args: args}
+}
+
+func exprs(list []ast.Expr, f *File) ([]luau.Node, error) {
+	res := make([]luau.Node, len(list))
+	for i, e := range list {
+		n, err := Expr(e, f)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = n
+	}
+	return res, nil
+}
@@ -0,0 +1,216 @@
+package transform
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// names of the results of a select in the statement which branches on them
+const (
+	selectCase  = "__case"
+	selectValue = "__recv"
+	selectOk    = "__recvok"
+)
+
+// Go statement, the function value and its arguments are evaluated immediately
+// and the call runs in a new goroutine
+// ex: go f(x) -> GO.go(f, x)
+func GoStmt(g *ast.GoStmt, f *File) (*luau.ExprStmt, error) {
+	call, err := CallExpr(g.Call, f)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []luau.Node{}
+	if c, ok := call.(*luau.CallExpr); ok {
+		args = append(args, c.Fun)
+		args = append(args, c.Args...)
+	} else {
+		args = append(args, &luau.FuncLit{
+			Params: []*luau.Ident{},
+			Chunk:  &luau.Chunk{List: []luau.Node{&luau.ExprStmt{X: call}}},
+		})
+	}
+
+	return &luau.ExprStmt{X: runtimeCall("go", args...)}, nil
+}
+
+// ex: c <- v -> GO.send(c, v)
+func SendStmt(s *ast.SendStmt, f *File) (*luau.ExprStmt, error) {
+	c, err := Expr(s.Chan, f)
+	if err != nil {
+		return nil, err
+	}
+	var t types.Type
+	if ch, ok := underlying(f.typeOf(s.Chan)).(*types.Chan); ok {
+		t = ch.Elem()
+	}
+	v, err := ExprAs(s.Value, t, f)
+	if err != nil {
+		return nil, err
+	}
+	return &luau.ExprStmt{X: runtimeCall("send", c, v)}, nil
+}
+
+// receive operation, it results in the value and whether the channel was open
+// ex: <-c -> GO.recv(c, 0)
+func recvCall(u *ast.UnaryExpr, f *File) (*luau.CallExpr, error) {
+	c, err := Expr(u.X, f)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []luau.Node{c}
+	if z := chanZero(u.X, f); !isNil(z) {
+		args = append(args, z)
+	}
+	return runtimeCall("recv", args...), nil
+}
+
+// zero value of the elements of a channel, received once it's closed
+func chanZero(c ast.Expr, f *File) luau.Node {
+	if ch, ok := underlying(f.typeOf(c)).(*types.Chan); ok {
+		return zero(ch.Elem(), f)
+	}
+	return &luau.Ident{Name: "nil"}
+}
+
+func isRecv(e ast.Expr) (*ast.UnaryExpr, bool) {
+	u, ok := ast.Unparen(e).(*ast.UnaryExpr)
+	return u, ok && u.Op == token.ARROW
+}
+
+// Select statement, the runtime picks a case and the statement branches on its index
+// ex: select { case v := <-c: ...; default: ... } ->
+// local __case, __v, __ok = GO.select({{ch = c, zero = 0}}, true)
+// if __case == 1 then local v = __v ... else ... end
+func SelectStmt(s *ast.SelectStmt, f *File) (luau.Node, error) {
+	cases := []luau.Node{}
+	var branches []*luau.IfStmt
+	var def *luau.DoStmt
+
+	for _, stmt := range s.Body.List {
+		cc := stmt.(*ast.CommClause)
+		body, err := Chunk(&ast.BlockStmt{List: cc.Body}, f)
+		if err != nil {
+			return nil, err
+		}
+
+		if cc.Comm == nil {
+			def = &luau.DoStmt{Chunk: body}
+			continue
+		}
+
+		var c ast.Expr
+		var elts []luau.Node
+		switch comm := cc.Comm.(type) {
+		case *ast.SendStmt:
+			c = comm.Chan
+			v, err := Expr(comm.Value, f)
+			if err != nil {
+				return nil, err
+			}
+			elts = []luau.Node{
+				&luau.KeyValueExpr{Key: &luau.Ident{Name: "send"}, Value: &luau.Ident{Name: "true"}},
+				&luau.KeyValueExpr{Key: &luau.Ident{Name: "value"}, Value: v},
+			}
+		case *ast.ExprStmt:
+			u, _ := isRecv(comm.X)
+			c = u.X
+		case *ast.AssignStmt:
+			u, _ := isRecv(comm.Rhs[0])
+			c = u.X
+
+			// the received values are assigned first thing in the branch
+			assign, err := selectAssign(comm, f)
+			if err != nil {
+				return nil, err
+			}
+			body.List = append(assign, body.List...)
+		}
+
+		ch, err := Expr(c, f)
+		if err != nil {
+			return nil, err
+		}
+		elts = append([]luau.Node{&luau.KeyValueExpr{Key: &luau.Ident{Name: "ch"}, Value: ch}}, elts...)
+		if _, ok := cc.Comm.(*ast.SendStmt); !ok {
+			if z := chanZero(c, f); !isNil(z) {
+				elts = append(elts, &luau.KeyValueExpr{Key: &luau.Ident{Name: "zero"}, Value: z})
+			}
+		}
+		cases = append(cases, &luau.TableLit{Elts: elts})
+
+		branches = append(branches, &luau.IfStmt{
+			Cond: &luau.BinaryExpr{
+				Left:  &luau.Ident{Name: selectCase},
+				Right: &luau.NumericLit{Value: strconv.Itoa(len(cases))},
+				Op:    luau.EQL,
+			},
+			Body: body,
+		})
+	}
+
+	list := []luau.Node{&luau.DeclStmt{
+		Scope: luau.LOCAL,
+		Names: []luau.Node{
+			&luau.Ident{Name: selectCase},
+			&luau.Ident{Name: selectValue},
+			&luau.Ident{Name: selectOk},
+		},
+		Values: []luau.Node{runtimeCall("select",
+			&luau.TableLit{Elts: cases},
+			&luau.Ident{Name: strconv.FormatBool(def != nil)},
+		)},
+	}}
+
+	// branches chain into elseifs, the default case is the last else
+	var tail luau.Node
+	if def != nil {
+		tail = def
+	}
+	for i := len(branches) - 1; i >= 0; i-- {
+		branches[i].Else = tail
+		tail = branches[i]
+	}
+	if tail != nil {
+		list = append(list, tail)
+	}
+
+	return &luau.DoStmt{Chunk: &luau.Chunk{List: list}}, nil
+}
+
+// assignment of the received value and ok of a select case
+// ex: case v, ok := <-c -> local v, ok = __v, __ok
+func selectAssign(a *ast.AssignStmt, f *File) ([]luau.Node, error) {
+	results := []luau.Node{&luau.Ident{Name: selectValue}, &luau.Ident{Name: selectOk}}
+
+	left := []luau.Node{}
+	names := []*ast.Ident{}
+	for _, e := range a.Lhs {
+		if id, ok := e.(*ast.Ident); ok && a.Tok == token.DEFINE {
+			left = append(left, Ident(id, f))
+			names = append(names, id)
+			continue
+		}
+
+		n, err := lvalue(e, f)
+		if err != nil {
+			return nil, err
+		}
+		left = append(left, n)
+	}
+
+	if a.Tok == token.DEFINE {
+		return append([]luau.Node{&luau.DeclStmt{
+			Scope:  luau.LOCAL,
+			Names:  left,
+			Values: results[:len(left)],
+		}}, boxVars(names, f)...), nil
+	}
+	return []luau.Node{&luau.AssignStmt{Left: left, Right: results[:len(left)]}}, nil
+}
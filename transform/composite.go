@@ -0,0 +1,193 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"strconv"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// CompositeLit transforms a composite literal according to its type,
+// literals with elided types (inside slices and maps) included
+func CompositeLit(l *ast.CompositeLit, f *File) (luau.Node, error) {
+	t := f.typeOf(l)
+	if t == nil {
+		return untypedCompositeLit(l, f)
+	}
+
+	// &T{} elided inside []*T{...}
+	if e := elem(t); e != nil {
+		t = e
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Struct:
+		return StructLit(l, t, u, f)
+	case *types.Map:
+		return MapLit(l, u, f)
+	case *types.Slice, *types.Array:
+		return ArrayLit(l, t, f)
+	}
+	return nil, fmt.Errorf("unknown composite literal of type %s", t)
+}
+
+// Struct literal, omitted fields are set to their zero values
+// ex: Point{1, 2} -> setmetatable({X = 1, Y = 2}, Point)
+func StructLit(l *ast.CompositeLit, t types.Type, s *types.Struct, f *File) (luau.Node, error) {
+	fields := map[string]types.Type{}
+	for i := range s.NumFields() {
+		fields[s.Field(i).Name()] = s.Field(i).Type()
+	}
+
+	values := map[string]luau.Node{}
+	for i, e := range l.Elts {
+		name := ""
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				return nil, fmt.Errorf("struct literal key must be a field name, got %#v", kv.Key)
+			}
+			name = key.Name
+			e = kv.Value
+		} else {
+			if i >= s.NumFields() {
+				return nil, errors.New("too many values in struct literal")
+			}
+			name = s.Field(i).Name()
+		}
+
+		v, err := ExprAs(e, fields[name], f)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+
+	elts := []luau.Node{}
+	for i := range s.NumFields() {
+		field := s.Field(i)
+		if field.Name() == "_" {
+			continue
+		}
+
+		v, ok := values[field.Name()]
+		if !ok {
+			v = zero(field.Type(), f)
+		}
+		elts = append(elts, &luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: Mangle(field.Name())},
+			Value: v,
+		})
+	}
+
+	return instance(t, &luau.TableLit{Elts: elts}, f), nil
+}
+
+// ex: map[string]int{k: 1} -> {[k] = 1}
+func MapLit(l *ast.CompositeLit, m *types.Map, f *File) (*luau.TableLit, error) {
+	elts := make([]luau.Node, len(l.Elts))
+	for i, e := range l.Elts {
+		kv, ok := e.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, errors.New("missing key in map literal")
+		}
+
+		key, err := MapKey(kv.Key, m.Key(), f)
+		if err != nil {
+			return nil, err
+		}
+		value, err := ExprAs(kv.Value, m.Elem(), f)
+		if err != nil {
+			return nil, err
+		}
+		res := &luau.KeyValueExpr{Key: key, Value: value}
+
+		// identifiers are field names in table constructors
+		if id, ok := res.Key.(*luau.Ident); ok {
+			res.Key = &luau.ParenExpr{X: id}
+		}
+		elts[i] = res
+	}
+
+	return &luau.TableLit{Elts: elts}, nil
+}
+
+// Slice and array literals, elements may be indexed and gaps are zeroed
+// ex: []int{1, 2} -> GO.newslice({1, 2}, 2), [3]int{2: 1} -> GO.newslice({0, 0, 1}, 3)
+func ArrayLit(l *ast.CompositeLit, t types.Type, f *File) (luau.Node, error) {
+	var et types.Type
+	n := int64(-1)
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		et = u.Elem()
+	case *types.Array:
+		et, n = u.Elem(), u.Len()
+	}
+
+	values := map[int64]luau.Node{}
+	var i, length int64
+	for _, e := range l.Elts {
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			if tv := f.Pkg.Info.Types[kv.Key]; tv.Value != nil {
+				i, _ = constant.Int64Val(constant.ToInt(tv.Value))
+			}
+			e = kv.Value
+		}
+
+		v, err := ExprAs(e, et, f)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+		i++
+		length = max(length, i)
+	}
+
+	if n < 0 {
+		n = length
+	}
+	elts := make([]luau.Node, n)
+	for i := range elts {
+		if v, ok := values[int64(i)]; ok {
+			elts[i] = v
+		} else {
+			elts[i] = zero(et, f)
+		}
+	}
+
+	return newSlice(&luau.TableLit{Elts: elts}, n), nil
+}
+
+// ex: GO.newslice({1, 2}, 2)
+func newSlice(t luau.Node, n int64) *luau.CallExpr {
+	return runtimeCall("newslice", t, &luau.NumericLit{Value: strconv.FormatInt(n, 10)})
+}
+
+// literals of unresolved types are plain tables,
+// unless they name a type which would lose its methods and zero values
+func untypedCompositeLit(l *ast.CompositeLit, f *File) (luau.Node, error) {
+	switch t := l.Type.(type) {
+	case *ast.ChanType:
+		return nil, errors.New("channels not supported")
+	case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+		// a table without the type's metatable would lack its methods and zero values
+		return nil, fmt.Errorf("unknown type of composite literal: %s", types.ExprString(t))
+	}
+
+	elts := make([]luau.Node, len(l.Elts))
+	for i, v := range l.Elts {
+		e, err := Expr(v, f)
+		if err != nil {
+			return nil, err
+		}
+		elts[i] = e
+	}
+
+	return &luau.TableLit{
+		Elts: elts,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package transform
+
+import (
+	"go/ast"
+	"go/types"
+	"strconv"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// Conversion transforms T(x), values of most types are represented
+// the same way so they pass through unchanged
+// ex: []byte(s) -> GO.bytes(s), int(f) -> (math.modf(f))
+func Conversion(c *ast.CallExpr, f *File) (luau.Node, error) {
+	if tv, ok := f.Pkg.Info.Types[c]; ok && tv.Value != nil {
+		if n, ok := constLit(tv.Value); ok {
+			return n, nil
+		}
+	}
+
+	x, err := Expr(c.Args[0], f)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := f.typeOf(c.Fun); t != nil && isInterface(t) {
+		return boxAs(x, f.typeOf(c.Args[0]), t, f), nil
+	}
+
+	to, from := underlying(f.typeOf(c.Fun)), underlying(f.typeOf(c.Args[0]))
+	if to == nil || from == nil {
+		return x, nil
+	}
+
+	switch to := to.(type) {
+	case *types.Basic:
+		switch from := from.(type) {
+		case *types.Slice:
+			if to.Info()&types.IsString == 0 {
+				break
+			}
+			if isByte(from.Elem()) {
+				return runtimeCall("bytestring", x), nil
+			}
+			return runtimeCall("runestring", x), nil
+		case *types.Basic:
+			switch {
+			case to.Info()&types.IsString != 0 && from.Info()&types.IsInteger != 0:
+				return runtimeCall("runestring", x), nil
+			case to.Info()&types.IsInteger != 0 && from.Info()&types.IsFloat != 0:
+				return wrapInt(&luau.ParenExpr{X: &luau.CallExpr{
+					Fun:  &luau.SelectorExpr{X: &luau.Ident{Name: "math"}, Sel: &luau.Ident{Name: "modf"}},
+					Args: []luau.Node{x},
+				}}, to), nil
+			case to.Info()&types.IsInteger != 0 && from.Info()&types.IsInteger != 0:
+				if f.Pkg.Info.Types[c.Args[0]].Value == nil {
+					return wrapInt(x, to), nil
+				}
+			}
+		}
+	case *types.Slice:
+		if b, ok := from.(*types.Basic); !ok || b.Info()&types.IsString == 0 {
+			break
+		}
+		if isByte(to.Elem()) {
+			return runtimeCall("bytes", x), nil
+		}
+		return runtimeCall("runeslice", x), nil
+	}
+	return x, nil
+}
+
+func isByte(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// integers narrower than a double's mantissa wrap around
+// ex: uint8(x) -> x % 256, int8(x) -> (x + 128) % 256 - 128
+func wrapInt(x luau.Node, t *types.Basic) luau.Node {
+	var bits int
+	switch t.Kind() {
+	case types.Int8, types.Uint8:
+		bits = 8
+	case types.Int16, types.Uint16:
+		bits = 16
+	case types.Int32, types.Uint32:
+		bits = 32
+	default:
+		return x
+	}
+
+	mod := &luau.NumericLit{Value: "2^" + strconv.Itoa(bits)}
+	if t.Info()&types.IsUnsigned != 0 {
+		return &luau.ParenExpr{X: &luau.BinaryExpr{Left: x, Right: mod, Op: luau.REM}}
+	}
+
+	half := &luau.NumericLit{Value: "2^" + strconv.Itoa(bits-1)}
+	return &luau.ParenExpr{X: &luau.BinaryExpr{
+		Left: &luau.BinaryExpr{
+			Left:  &luau.ParenExpr{X: &luau.BinaryExpr{Left: x, Right: half, Op: luau.ADD}},
+			Right: mod,
+			Op:    luau.REM,
+		},
+		Right: half,
+		Op:    luau.SUB,
+	}}
+}
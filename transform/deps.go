@@ -0,0 +1,172 @@
+package transform
+
+import (
+	"go/ast"
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// TopDecl transforms a top-level declaration.
+// Every function and spec is wrapped into luau.Decl along with the top-level names it refers to,
+// so that the file renders them in dependency order
+func TopDecl(d ast.Decl, f *File) ([]luau.Node, error) {
+	switch decl := d.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil && decl.Name.Name == "init" {
+			return InitDecl(decl, f)
+		}
+
+		fn, err := FuncDecl(decl, f)
+		if err != nil {
+			return nil, err
+		}
+
+		return []luau.Node{&luau.Decl{
+			Node:  fn,
+			Names: []string{fn.Name.Name},
+			Deps:  deps(decl, f),
+		}}, nil
+	case *ast.GenDecl:
+		res := []luau.Node{}
+		for _, s := range decl.Specs {
+			spec, err := Spec(s, f)
+			if err != nil {
+				return nil, err
+			}
+
+			// transform.Mod
+			if raw, ok := spec.(*luau.Raw); ok {
+				res = append(res, raw)
+				continue
+			}
+
+			res = append(res, &luau.Decl{
+				Node:  spec,
+				Names: specNames(s, f),
+				Deps:  deps(s, f),
+			})
+		}
+		return res, nil
+	}
+
+	n, err := Decl(d, f)
+	if err != nil {
+		return nil, err
+	}
+	return []luau.Node{n}, nil
+}
+
+// Package initializer, ran once every variable is initialized.
+// Packages may have several of them, so they are scoped
+// ex: do local function init() end init() end
+func InitDecl(d *ast.FuncDecl, f *File) ([]luau.Node, error) {
+	c, err := FuncBody(nil, d.Type, d.Body, f)
+	if err != nil {
+		return nil, err
+	}
+
+	name := &luau.Ident{Name: "init"}
+	do := &luau.DoStmt{
+		Chunk: &luau.Chunk{
+			List: []luau.Node{
+				&luau.FuncStmt{
+					Name:   name,
+					Params: []*luau.Ident{},
+					Chunk:  c,
+					Scope:  luau.LOCAL,
+				},
+				&luau.ExprStmt{
+					X: &luau.CallExpr{Fun: name, Args: []luau.Node{}},
+				},
+			},
+		},
+	}
+
+	// every package variable counts as a dependency
+	dd := deps(d, f)
+	scope := f.Pkg.Types.Scope()
+	for _, n := range scope.Names() {
+		if _, ok := scope.Lookup(n).(*types.Var); ok {
+			dd = append(dd, Mangle(n))
+		}
+	}
+
+	return []luau.Node{&luau.Decl{
+		Node:  do,
+		Names: []string{},
+		Deps:  dd,
+	}}, nil
+}
+
+func specNames(s ast.Spec, f *File) []string {
+	names := []string{}
+	switch spec := s.(type) {
+	case *ast.ValueSpec:
+		for _, n := range spec.Names {
+			if n.Name != "_" {
+				names = append(names, Ident(n, f).Name)
+			}
+		}
+	case *ast.TypeSpec:
+		names = append(names, Ident(spec.Name, f).Name)
+	case *ast.ImportSpec:
+		if name := importLocal(spec, f); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// top-level names a node refers to, methods are named after their type
+// ex: T.M
+func deps(n ast.Node, f *File) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			res = append(res, name)
+		}
+	}
+
+	scope := f.Pkg.Types.Scope()
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			if obj := f.Pkg.Info.Uses[n]; obj != nil && obj.Parent() == scope {
+				add(Ident(n, f).Name)
+			}
+			// package names live in the file scope
+			if pn, ok := f.Pkg.Info.Uses[n].(*types.PkgName); ok {
+				add(f.pkgLocal(pn).Name)
+			} else if pn := f.Pkg.unresolvedImport(f.File, n); pn != nil {
+				add(f.pkgLocal(pn).Name)
+			}
+			if obj, ok := f.foreign(n); ok {
+				if ref, ok := f.pkgRef(obj.Pkg()).(*luau.Ident); ok {
+					add(ref.Name)
+				}
+			}
+		case *ast.SelectorExpr:
+			sel, ok := f.Pkg.Info.Selections[n]
+			if !ok || sel.Kind() == types.FieldVal {
+				break
+			}
+
+			recv := sel.Obj().Type().(*types.Signature).Recv()
+			if recv == nil {
+				break
+			}
+			t := recv.Type()
+			if p, ok := t.(*types.Pointer); ok {
+				t = p.Elem()
+			}
+			if named, ok := t.(*types.Named); ok && named.Obj().Pkg() == f.Pkg.Types {
+				add(Mangle(named.Obj().Name()) + "." + Mangle(sel.Obj().Name()))
+			}
+		}
+		return true
+	})
+	return res
+}
@@ -0,0 +1,301 @@
+package transform
+
+import (
+	"go/ast"
+	"go/types"
+	"strconv"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// Descriptor describes a named type to the runtime,
+// it's stored in the type's table so that instances reach it through their metatable,
+// and types of the package are registered by their import path and name
+// ex: T.__type = {name = "T", pkg = "main", path = "main", kind = "struct", fields = {...}, methods = {"String"}}
+func Descriptor(t *ast.TypeSpec, f *File) luau.Node {
+	obj, ok := f.objectOf(t.Name).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+
+	elts := []luau.Node{
+		&luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: "name"},
+			Value: &luau.StringLit{Value: obj.Name()},
+		},
+		&luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: "pkg"},
+			Value: &luau.StringLit{Value: f.Pkg.Types.Name()},
+		},
+		&luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: "path"},
+			Value: &luau.StringLit{Value: f.Pkg.Types.Path()},
+		},
+	}
+
+	u := obj.Type().Underlying()
+	elts = append(elts, &luau.KeyValueExpr{
+		Key:   &luau.Ident{Name: "kind"},
+		Value: &luau.StringLit{Value: kind(u)},
+	})
+	if s, ok := u.(*types.Struct); ok {
+		elts = append(elts, &luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: "fields"},
+			Value: fieldInfo(s, f),
+		})
+	} else if _, ok := u.(*types.Interface); !ok {
+		elts = append(elts, &luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: "underlying"},
+			Value: typeInfo(u, f),
+		})
+	}
+
+	// the methods of interfaces, declared and promoted ones otherwise
+	methods := []luau.Node{}
+	if i, ok := u.(*types.Interface); ok {
+		for k := range i.NumMethods() {
+			methods = append(methods, &luau.StringLit{Value: Mangle(i.Method(k).Name())})
+		}
+	} else if n, ok := obj.Type().(*types.Named); ok {
+		for k := range n.NumMethods() {
+			methods = append(methods, &luau.StringLit{Value: Mangle(n.Method(k).Name())})
+		}
+		for _, sel := range promotedMethods(n) {
+			methods = append(methods, &luau.StringLit{Value: Mangle(sel.Obj().Name())})
+		}
+	}
+	elts = append(elts, &luau.KeyValueExpr{
+		Key:   &luau.Ident{Name: "methods"},
+		Value: &luau.TableLit{Elts: methods},
+	})
+	// pointers to values other than structs and arrays are boxes,
+	// which are passed as they are to the methods with pointer receivers
+	if n, ok := obj.Type().(*types.Named); ok && !isAggregate(u) {
+		ptrMethods := []luau.Node{}
+		for k := range n.NumMethods() {
+			m := n.Method(k)
+			if _, ok := m.Signature().Recv().Type().(*types.Pointer); ok {
+				ptrMethods = append(ptrMethods, &luau.StringLit{Value: Mangle(m.Name())})
+			}
+		}
+		if len(ptrMethods) > 0 {
+			elts = append(elts, &luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: "ptrmethods"},
+				Value: &luau.TableLit{Elts: ptrMethods},
+			})
+		}
+	}
+	// instances are made from the descriptor when decoding into them
+	if isAggregate(u) {
+		elts = append(elts, &luau.KeyValueExpr{
+			Key: &luau.Ident{Name: "zero"},
+			Value: &luau.FuncLit{
+				Params: []*luau.Ident{},
+				Chunk:  &luau.Chunk{List: []luau.Node{&luau.ReturnStmt{Results: []luau.Node{zero(obj.Type(), f)}}}},
+			},
+		})
+	}
+
+	assign := &luau.AssignStmt{
+		Left: []luau.Node{&luau.SelectorExpr{
+			X:   Ident(t.Name, f),
+			Sel: &luau.Ident{Name: "__type"},
+		}},
+		Right: []luau.Node{&luau.TableLit{Elts: elts}},
+	}
+
+	// types declared inside of functions can't be looked up by name
+	if obj.Parent() != f.Pkg.Types.Scope() {
+		return assign
+	}
+	return &luau.Block{List: []luau.Node{
+		assign,
+		&luau.ExprStmt{X: runtimeCall("register", Ident(t.Name, f))},
+	}}
+}
+
+// typeDescriptor is the descriptor of a type for the runtime to check values against,
+// interfaces are described by their methods and pointers to structs by the structs
+// ex: PathError.__type, {kind = "interface", methods = {"Timeout"}}
+func typeDescriptor(t types.Type, f *File) luau.Node {
+	if t == nil {
+		return &luau.Ident{Name: "nil"}
+	}
+	if p, ok := types.Unalias(t).(*types.Pointer); ok {
+		t = p.Elem()
+	}
+
+	if i, ok := t.Underlying().(*types.Interface); ok {
+		return interfaceInfo(i)
+	}
+
+	if n, ok := types.Unalias(t).(*types.Named); ok {
+		return &luau.SelectorExpr{X: typeName(n, f), Sel: &luau.Ident{Name: "__type"}}
+	}
+	return &luau.Ident{Name: "nil"}
+}
+
+// typeInfo describes the structure of a type for the runtime to encode and decode values with,
+// named types are their tables and the runtime reads their descriptors from them
+// ex: {kind = "slice", elem = {kind = "string"}}, Point
+func typeInfo(t types.Type, f *File) luau.Node {
+	if t == nil {
+		return &luau.Ident{Name: "nil"}
+	}
+	t = types.Unalias(t)
+
+	if n, ok := t.(*types.Named); ok && n.Obj().Pkg() != nil {
+		pkg := n.Obj().Pkg()
+		if pkg == f.Pkg.Types {
+			return typeName(n, f)
+		}
+		if n.Obj().Exported() && f.pkgRef(pkg) != nil {
+			return typeName(n, f)
+		}
+		// types this file can't refer to by name are looked up where their package registered them,
+		// which is loaded before as a dependency of an import
+		if n.Obj().Parent() != pkg.Scope() {
+			return &luau.Ident{Name: "nil"}
+		}
+		return &luau.IndexExpr{
+			X:     runtimeFunc("types"),
+			Index: &luau.StringLit{Value: pkg.Path() + "." + n.Obj().Name()},
+		}
+	}
+
+	elts := []luau.Node{&luau.KeyValueExpr{
+		Key:   &luau.Ident{Name: "kind"},
+		Value: &luau.StringLit{Value: kind(t)},
+	}}
+	field := func(name string, value luau.Node) {
+		elts = append(elts, &luau.KeyValueExpr{Key: &luau.Ident{Name: name}, Value: value})
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Info()&types.IsUntyped != 0 {
+			return &luau.Ident{Name: "nil"}
+		}
+	case *types.Pointer:
+		field("elem", typeInfo(u.Elem(), f))
+	case *types.Slice:
+		field("elem", typeInfo(u.Elem(), f))
+	case *types.Array:
+		field("len", &luau.NumericLit{Value: strconv.FormatInt(u.Len(), 10)})
+		field("elem", typeInfo(u.Elem(), f))
+	case *types.Map:
+		field("key", typeInfo(u.Key(), f))
+		field("elem", typeInfo(u.Elem(), f))
+	case *types.Chan:
+		switch u.Dir() {
+		case types.SendOnly:
+			field("dir", &luau.StringLit{Value: "send"})
+		case types.RecvOnly:
+			field("dir", &luau.StringLit{Value: "recv"})
+		}
+		field("elem", typeInfo(u.Elem(), f))
+	case *types.Signature:
+		tuple := func(t *types.Tuple) *luau.TableLit {
+			ts := []luau.Node{}
+			for i := range t.Len() {
+				ts = append(ts, typeInfo(t.At(i).Type(), f))
+			}
+			return &luau.TableLit{Elts: ts}
+		}
+		field("params", tuple(u.Params()))
+		field("results", tuple(u.Results()))
+		if u.Variadic() {
+			field("variadic", &luau.Ident{Name: "true"})
+		}
+	case *types.Struct:
+		field("fields", fieldInfo(u, f))
+	case *types.Interface:
+		return interfaceInfo(u)
+	}
+	return &luau.TableLit{Elts: elts}
+}
+
+// fields of a struct with their types and tags
+// ex: {{name = "Name", key = "Name", type = {kind = "string"}, tag = "json:\"name\""}}
+func fieldInfo(s *types.Struct, f *File) *luau.TableLit {
+	fields := []luau.Node{}
+	for i := range s.NumFields() {
+		field := s.Field(i)
+		elts := []luau.Node{
+			&luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: "name"},
+				Value: &luau.StringLit{Value: field.Name()},
+			},
+			&luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: "key"},
+				Value: &luau.StringLit{Value: Mangle(field.Name())},
+			},
+			&luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: "type"},
+				Value: typeInfo(field.Type(), f),
+			},
+		}
+		if tag := s.Tag(i); tag != "" {
+			q := strconv.Quote(tag)
+			elts = append(elts, &luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: "tag"},
+				Value: &luau.StringLit{Value: q[1 : len(q)-1]},
+			})
+		}
+		if field.Embedded() {
+			elts = append(elts, &luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: "embedded"},
+				Value: &luau.Ident{Name: "true"},
+			})
+		}
+		fields = append(fields, &luau.TableLit{Elts: elts})
+	}
+	return &luau.TableLit{Elts: fields}
+}
+
+// interfaces are described by their methods
+// ex: {kind = "interface", methods = {"Error"}}
+func interfaceInfo(i *types.Interface) luau.Node {
+	methods := []luau.Node{}
+	for k := range i.NumMethods() {
+		methods = append(methods, &luau.StringLit{Value: Mangle(i.Method(k).Name())})
+	}
+	return &luau.TableLit{Elts: []luau.Node{
+		&luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: "kind"},
+			Value: &luau.StringLit{Value: "interface"},
+		},
+		&luau.KeyValueExpr{
+			Key:   &luau.Ident{Name: "methods"},
+			Value: &luau.TableLit{Elts: methods},
+		},
+	}}
+}
+
+// kind of a type after reflect.Kind
+func kind(t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Kind() == types.UnsafePointer {
+			return "unsafe.Pointer"
+		}
+		return types.Typ[u.Kind()].Name()
+	case *types.Pointer:
+		return "ptr"
+	case *types.Slice:
+		return "slice"
+	case *types.Array:
+		return "array"
+	case *types.Map:
+		return "map"
+	case *types.Chan:
+		return "chan"
+	case *types.Signature:
+		return "func"
+	case *types.Struct:
+		return "struct"
+	case *types.Interface:
+		return "interface"
+	}
+	return "invalid"
+}
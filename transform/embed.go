@@ -0,0 +1,93 @@
+package transform
+
+import (
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// promoted walks the embedded fields a promoted field or method is selected through,
+// returning the embedded value and its type
+// ex: c.ID -> c.Base.ID
+func promoted(x luau.Node, sel *types.Selection) (luau.Node, types.Type) {
+	t := sel.Recv()
+	index := sel.Index()
+	for _, i := range index[:len(index)-1] {
+		if p, ok := t.Underlying().(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		s, ok := t.Underlying().(*types.Struct)
+		if !ok {
+			break
+		}
+
+		field := s.Field(i)
+		x = &luau.SelectorExpr{X: x, Sel: &luau.Ident{Name: Mangle(field.Name())}}
+		t = field.Type()
+	}
+	return x, t
+}
+
+// promotedRecv is the receiver a promoted method is called with,
+// the embedded value is referenced or dereferenced to match the method's receiver
+// ex: c.Lock() -> sync.Mutex.Lock(c.Mutex)
+func promotedRecv(x luau.Node, sel *types.Selection) luau.Node {
+	x, t := promoted(x, sel)
+
+	sig := sel.Obj().Type().(*types.Signature)
+	_, wantPtr := sig.Recv().Type().(*types.Pointer)
+	p, isPtr := t.Underlying().(*types.Pointer)
+	switch {
+	case wantPtr && !isPtr && !isAggregate(t):
+		if s, ok := x.(*luau.SelectorExpr); ok {
+			return ref(s.X, &luau.StringLit{Value: s.Sel.Name})
+		}
+	case !wantPtr && isPtr && !isAggregate(p.Elem()):
+		return unbox(x)
+	}
+	return x
+}
+
+// methods promoted from the embedded fields of a struct type
+func promotedMethods(n *types.Named) []*types.Selection {
+	if _, ok := n.Underlying().(*types.Struct); !ok {
+		return nil
+	}
+
+	res := []*types.Selection{}
+	ms := types.NewMethodSet(types.NewPointer(n))
+	for i := range ms.Len() {
+		if sel := ms.At(i); len(sel.Index()) > 1 {
+			res = append(res, sel)
+		}
+	}
+	return res
+}
+
+// PromotedMethods forwards the methods promoted to a struct type to its embedded fields,
+// so that its instances have them when stored in interfaces
+// ex: function Counter.Lock(self, ...) return sync.Mutex.Lock(self.Mutex, ...) end
+func PromotedMethods(n *types.Named, f *File) []luau.Node {
+	res := []luau.Node{}
+	for _, sel := range promotedMethods(n) {
+		self := &luau.Ident{Name: "self"}
+		name := Mangle(sel.Obj().Name())
+
+		fn := methodFunc(sel, f)
+		if fn == nil {
+			// methods of embedded interfaces are looked up on the value
+			x, _ := promoted(self, sel)
+			fn = &luau.SelectorExpr{X: x, Sel: &luau.Ident{Name: name}}
+		}
+
+		res = append(res, &luau.FuncStmt{
+			Name:   &luau.Ident{Name: Mangle(n.Obj().Name()) + "." + name},
+			Params: []*luau.Ident{self, {Name: "..."}},
+			Scope:  luau.NONE,
+			Chunk: &luau.Chunk{List: []luau.Node{&luau.ReturnStmt{Results: []luau.Node{
+				&luau.CallExpr{Fun: fn, Args: []luau.Node{promotedRecv(self, sel), &luau.Ident{Name: "..."}}},
+			}}}},
+		})
+	}
+	return res
+}
@@ -0,0 +1,78 @@
+package transform
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// Equality lowers == and != of values Luau would compare by identity,
+// structs and arrays are compared by their elements and interfaces by their dynamic types and values.
+// Other comparisons, and ones against nil, stay as they are
+// ex: p == q -> GO.equal(p,q,Point), err != target -> not GO.equal(err,target)
+func Equality(e *ast.BinaryExpr, f *File) (luau.Node, bool, error) {
+	if e.Op != token.EQL && e.Op != token.NEQ {
+		return nil, false, nil
+	}
+	x, y := f.typeOf(e.X), f.typeOf(e.Y)
+	if x == nil || y == nil || f.Pkg.Info.Types[e.X].IsNil() || f.Pkg.Info.Types[e.Y].IsNil() {
+		return nil, false, nil
+	}
+
+	args := []luau.Node{}
+	switch {
+	case isInterface(x) || isInterface(y):
+		// the concrete operand is converted to the interface, scalars then compare by value
+		left, err := ExprAs(e.X, y, f)
+		if err != nil {
+			return nil, true, err
+		}
+		right, err := ExprAs(e.Y, x, f)
+		if err != nil {
+			return nil, true, err
+		}
+		if isInterface(x) != isInterface(y) && !isAggregate(x) && !isAggregate(y) {
+			return &luau.BinaryExpr{Left: left, Right: right, Op: Token(e.Op)}, true, nil
+		}
+		args = append(args, left, right)
+	case isAggregate(x):
+		left, err := Expr(e.X, f)
+		if err != nil {
+			return nil, true, err
+		}
+		right, err := Expr(e.Y, f)
+		if err != nil {
+			return nil, true, err
+		}
+		args = append(args, left, right, typeInfo(x, f))
+	default:
+		return nil, false, nil
+	}
+
+	var n luau.Node = runtimeCall("equal", args...)
+	if e.Op == token.NEQ {
+		n = &luau.UnaryExpr{Op: luau.NOT, X: n}
+	}
+	return n, true, nil
+}
+
+// MapKey transforms the key of a map whose keys are of type t.
+// Equal structs, arrays and values in interfaces have to key the same entry, so the runtime interns them,
+// other keys index the table as they are
+// ex: grid[Vec2{1, 2}] -> grid[GO.key(setmetatable({X = 1, Y = 2},Vec2),Vec2)]
+func MapKey(e ast.Expr, t types.Type, f *File) (luau.Node, error) {
+	n, err := ExprAs(e, t, f)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isInterface(t):
+		return runtimeCall("key", n), nil
+	case isAggregate(t):
+		return runtimeCall("key", n, typeInfo(t, f)), nil
+	}
+	return n, nil
+}
@@ -0,0 +1,143 @@
+package transform
+
+import (
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// Exports builds the table returned by a package's module.
+// Exported functions, types (along with their methods) and constants are its fields,
+// while exported variables are accessed through the metatable,
+// so that other packages read and write the current value
+// ex: return setmetatable({F = F}, {__index = ..., __newindex = ...})
+func Exports(pkg *Package) luau.Node {
+	lit := &luau.TableLit{
+		Elts: []luau.Node{},
+	}
+	vars := []types.Object{}
+
+	if pkg.Types != nil {
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			obj := scope.Lookup(name)
+			if !obj.Exported() {
+				continue
+			}
+
+			switch obj.(type) {
+			case *types.Func, *types.TypeName, *types.Const:
+				lit.Elts = append(lit.Elts, &luau.KeyValueExpr{
+					Key:   &luau.Ident{Name: name},
+					Value: &luau.Ident{Name: Mangle(name)},
+				})
+			case *types.Var:
+				vars = append(vars, obj)
+			}
+		}
+	}
+
+	if len(vars) == 0 {
+		return &luau.ReturnStmt{
+			Results: []luau.Node{lit},
+		}
+	}
+
+	return &luau.ReturnStmt{
+		Results: []luau.Node{
+			&luau.CallExpr{
+				Fun: &luau.Ident{Name: "setmetatable"},
+				Args: []luau.Node{
+					lit,
+					&luau.TableLit{
+						Elts: []luau.Node{
+							&luau.KeyValueExpr{
+								Key:   &luau.Ident{Name: "__index"},
+								Value: varGetter(vars, pkg),
+							},
+							&luau.KeyValueExpr{
+								Key:   &luau.Ident{Name: "__newindex"},
+								Value: varSetter(vars, pkg),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// expression accessing a package variable
+func varAccess(obj types.Object, pkg *Package) luau.Node {
+	id := &luau.Ident{Name: Mangle(obj.Name())}
+	if pkg.boxed[obj] {
+		return unbox(id)
+	}
+	return id
+}
+
+// if key == "X" then ... elseif key == "Y" then ... end
+func keySwitch(vars []types.Object, body func(obj types.Object) []luau.Node) luau.Node {
+	var res luau.Node
+	for i := len(vars) - 1; i >= 0; i-- {
+		res = &luau.IfStmt{
+			Cond: &luau.BinaryExpr{
+				Left:  &luau.Ident{Name: "key"},
+				Right: &luau.StringLit{Value: vars[i].Name()},
+				Op:    luau.EQL,
+			},
+			Body: &luau.Chunk{List: body(vars[i])},
+			Else: res,
+		}
+	}
+	return res
+}
+
+// function(_, key) if key == "X" then return X end end
+func varGetter(vars []types.Object, pkg *Package) *luau.FuncLit {
+	return &luau.FuncLit{
+		Params: []*luau.Ident{{Name: "_"}, {Name: "key"}},
+		Chunk: &luau.Chunk{
+			List: []luau.Node{
+				keySwitch(vars, func(obj types.Object) []luau.Node {
+					return []luau.Node{
+						&luau.ReturnStmt{Results: []luau.Node{varAccess(obj, pkg)}},
+					}
+				}),
+			},
+		},
+	}
+}
+
+// function(_, key, value) if key == "X" then X = value return end error(...) end
+func varSetter(vars []types.Object, pkg *Package) *luau.FuncLit {
+	return &luau.FuncLit{
+		Params: []*luau.Ident{{Name: "_"}, {Name: "key"}, {Name: "value"}},
+		Chunk: &luau.Chunk{
+			List: []luau.Node{
+				keySwitch(vars, func(obj types.Object) []luau.Node {
+					return []luau.Node{
+						&luau.AssignStmt{
+							Left:  []luau.Node{varAccess(obj, pkg)},
+							Right: []luau.Node{&luau.Ident{Name: "value"}},
+						},
+						&luau.ReturnStmt{},
+					}
+				}),
+				&luau.ExprStmt{
+					X: &luau.CallExpr{
+						Fun: &luau.Ident{Name: "error"},
+						Args: []luau.Node{
+							&luau.BinaryExpr{
+								Left:  &luau.StringLit{Value: "cannot assign to "},
+								Right: &luau.CallExpr{Fun: &luau.Ident{Name: "tostring"}, Args: []luau.Node{&luau.Ident{Name: "key"}}},
+								Op:    luau.CCT,
+							},
+							&luau.NumericLit{Value: "2"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
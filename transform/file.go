@@ -0,0 +1,35 @@
+package transform
+
+import "go/ast"
+
+// File holds the state of a single Go file being transformed
+type File struct {
+	*ast.File
+	Pkg *Package
+
+	funcs []*funcScope // enclosing functions, innermost last
+}
+
+func NewFile(f *ast.File, pkg *Package) *File {
+	return &File{
+		File:  f,
+		Pkg:   pkg,
+		funcs: []*funcScope{},
+	}
+}
+
+func (f *File) pushFunc(s *funcScope) {
+	f.funcs = append(f.funcs, s)
+}
+
+func (f *File) popFunc() {
+	f.funcs = f.funcs[:len(f.funcs)-1]
+}
+
+// currently transformed function, nil at the top level
+func (f *File) fn() *funcScope {
+	if len(f.funcs) == 0 {
+		return nil
+	}
+	return f.funcs[len(f.funcs)-1]
+}
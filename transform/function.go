@@ -0,0 +1,279 @@
+package transform
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// names reserved for the function prologue
+const (
+	defersName = "__defers"
+	okName     = "__ok"
+	errName    = "__err"
+)
+
+// funcScope describes the function whose body is being transformed
+type funcScope struct {
+	// result variables, empty if results are unnamed and nothing is deferred
+	names []*ast.Ident
+	decl  []luau.Node
+	zeros []luau.Node
+	// expressions accessing the result variables
+	results []luau.Node
+	// body contains a defer statement, so it runs inside of pcall
+	defers bool
+	// types of the results, nil if some are unknown
+	types *types.Tuple
+	// depth of the range over function bodies being transformed,
+	// which return from the enclosing function by stopping the iteration
+	yields int
+}
+
+// check whether a block returns, ignoring nested function literals
+func hasReturn(b *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(b, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// check whether a function body defers calls, ignoring nested function literals
+func hasDefer(b *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(b, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.DeferStmt:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func newFuncScope(t *ast.FuncType, b *ast.BlockStmt, f *File) *funcScope {
+	s := &funcScope{
+		names:   []*ast.Ident{},
+		decl:    []luau.Node{},
+		zeros:   []luau.Node{},
+		results: []luau.Node{},
+		defers:  hasDefer(b),
+	}
+	if t.Results == nil {
+		return s
+	}
+	s.types = resultTypes(t.Results, f)
+
+	for _, r := range t.Results.List {
+		// unnamed results only need a variable to survive the deferred calls
+		if len(r.Names) == 0 {
+			if s.defers {
+				id := &luau.Ident{Name: fmt.Sprintf("__r%d", len(s.results))}
+				s.decl = append(s.decl, id)
+				s.results = append(s.results, id)
+				s.zeros = append(s.zeros, zeroValue(r.Type, f))
+			}
+			continue
+		}
+
+		for _, n := range r.Names {
+			if n.Name == "_" {
+				id := &luau.Ident{Name: fmt.Sprintf("__r%d", len(s.results))}
+				s.decl = append(s.decl, id)
+				s.results = append(s.results, id)
+			} else {
+				s.names = append(s.names, n)
+				s.decl = append(s.decl, Ident(n, f))
+				s.results = append(s.results, IdentExpr(n, f))
+			}
+			s.zeros = append(s.zeros, zeroValue(r.Type, f))
+		}
+	}
+	return s
+}
+
+// types of a result list, function declarations have no type recorded for their signatures
+func resultTypes(ls *ast.FieldList, f *File) *types.Tuple {
+	vars := []*types.Var{}
+	for _, r := range ls.List {
+		t := f.typeOf(r.Type)
+		if t == nil {
+			return nil
+		}
+		for range max(len(r.Names), 1) {
+			vars = append(vars, types.NewVar(r.Pos(), f.Pkg.Types, "", t))
+		}
+	}
+	return types.NewTuple(vars...)
+}
+
+func paramNames(ls *ast.FieldList) []*ast.Ident {
+	names := []*ast.Ident{}
+	if ls == nil {
+		return names
+	}
+	for _, l := range ls.List {
+		names = append(names, l.Names...)
+	}
+	return names
+}
+
+// variadic parameter of a function type, nil if it has none
+func variadicParam(t *ast.FuncType) *ast.Ident {
+	if t.Params == nil || len(t.Params.List) == 0 {
+		return nil
+	}
+	last := t.Params.List[len(t.Params.List)-1]
+	if _, ok := last.Type.(*ast.Ellipsis); !ok || len(last.Names) == 0 {
+		return nil
+	}
+	return last.Names[0]
+}
+
+// variadic parameters are Luau varargs, collected into a slice by the body
+func Params(t *ast.FuncType, f *File) []*luau.Ident {
+	params := []*luau.Ident{}
+	v := variadicParam(t)
+	for _, p := range paramNames(t.Params) {
+		if p == v {
+			params = append(params, &luau.Ident{Name: "..."})
+			continue
+		}
+		params = append(params, Ident(p, f))
+	}
+	return params
+}
+
+// FuncBody transforms the body of a function declaration or literal.
+// Named results are declared as locals with their zero values,
+// and when the body defers calls it is wrapped into pcall
+// so that deferred calls run (and may modify results) before returning
+func FuncBody(recv *ast.FieldList, t *ast.FuncType, b *ast.BlockStmt, f *File) (*luau.Chunk, error) {
+	s := newFuncScope(t, b, f)
+
+	f.pushFunc(s)
+	c, err := Chunk(b, f)
+	f.popFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []luau.Node{}
+
+	// ex: local xs = GO.variadic(...)
+	if v := variadicParam(t); v != nil && v.Name != "_" {
+		list = append(list, &luau.DeclStmt{
+			Scope:  luau.LOCAL,
+			Names:  []luau.Node{Ident(v, f)},
+			Values: []luau.Node{runtimeCall("variadic", &luau.Ident{Name: "..."})},
+		})
+	}
+
+	// parameters which have their address taken
+	list = append(list, boxVars(append(paramNames(recv), paramNames(t.Params)...), f)...)
+
+	if len(s.decl) > 0 {
+		list = append(list, &luau.DeclStmt{
+			Scope:  luau.LOCAL,
+			Names:  s.decl,
+			Values: s.zeros,
+		})
+		list = append(list, boxVars(s.names, f)...)
+	}
+
+	if !s.defers {
+		list = append(list, c.List...)
+		return &luau.Chunk{List: list}, nil
+	}
+
+	list = append(list,
+		&luau.DeclStmt{
+			Scope:  luau.LOCAL,
+			Names:  []luau.Node{&luau.Ident{Name: defersName}},
+			Values: []luau.Node{&luau.TableLit{}},
+		},
+		&luau.DeclStmt{
+			Scope: luau.LOCAL,
+			Names: []luau.Node{&luau.Ident{Name: okName}, &luau.Ident{Name: errName}},
+			Values: []luau.Node{&luau.CallExpr{
+				Fun:  &luau.Ident{Name: "pcall"},
+				Args: []luau.Node{&luau.FuncLit{Params: []*luau.Ident{}, Chunk: c}},
+			}},
+		},
+		&luau.ExprStmt{
+			X: &luau.CallExpr{
+				Fun: runtimeFunc("rundefers"),
+				Args: []luau.Node{
+					&luau.Ident{Name: defersName},
+					&luau.Ident{Name: okName},
+					&luau.Ident{Name: errName},
+				},
+			},
+		},
+	)
+
+	if len(s.results) > 0 {
+		list = append(list, &luau.ReturnStmt{Results: s.results})
+	}
+
+	return &luau.Chunk{List: list}, nil
+}
+
+func FuncLit(l *ast.FuncLit, f *File) (*luau.FuncLit, error) {
+	c, err := FuncBody(nil, l.Type, l.Body, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &luau.FuncLit{
+		Params: Params(l.Type, f),
+		Chunk:  c,
+	}, nil
+}
+
+// Defer statement
+// the function value and its arguments are evaluated immediately,
+// the call itself happens when the surrounding function returns
+func DeferStmt(d *ast.DeferStmt, f *File) (*luau.ExprStmt, error) {
+	call, err := CallExpr(d.Call, f)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []luau.Node{&luau.Ident{Name: defersName}}
+	if c, ok := call.(*luau.CallExpr); ok {
+		args = append(args, c.Fun)
+		args = append(args, c.Args...)
+	} else {
+		args = append(args, &luau.FuncLit{
+			Params: []*luau.Ident{},
+			Chunk:  &luau.Chunk{List: []luau.Node{&luau.ExprStmt{X: call}}},
+		})
+	}
+
+	return &luau.ExprStmt{
+		X: &luau.CallExpr{
+			Fun:  runtimeFunc("defer"),
+			Args: args,
+		},
+	}, nil
+}
+
+// zero value of a type expression
+func zeroValue(t ast.Expr, f *File) luau.Node {
+	if typ := f.typeOf(t); typ != nil {
+		return zero(typ, f)
+	}
+	return &luau.Ident{Name: "nil"}
+}
@@ -0,0 +1,119 @@
+package transform
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// ExprAs transforms an expression assigned to a variable of type t.
+// Values of named types other than pointers are boxed with their type when stored in interfaces,
+// and nil pointers, slices, maps, funcs and channels become typed nils which aren't nil interfaces
+// ex: var s fmt.Stringer = Celsius(3) -> local s = GO.box(3,Celsius)
+// ex: var err error = p -> local err = (p or GO.typednil({kind = "ptr", elem = PathError}))
+func ExprAs(e ast.Expr, t types.Type, f *File) (luau.Node, error) {
+	n, err := Expr(e, f)
+	if err != nil {
+		return nil, err
+	}
+	// addresses are never nil
+	if u, ok := ast.Unparen(e).(*ast.UnaryExpr); ok && u.Op == token.AND {
+		return n, nil
+	}
+	return boxAs(n, f.typeOf(e), t, f), nil
+}
+
+func boxAs(n luau.Node, from, to types.Type, f *File) luau.Node {
+	if from == nil || to == nil || !isInterface(to) || isInterface(from) {
+		return n
+	}
+
+	named, ok := types.Unalias(from).(*types.Named)
+	if ok && !isPointer(named) {
+		// types of other packages which can't be referred to stay unboxed
+		t := typeInfo(named, f)
+		if isNil(t) {
+			return n
+		}
+		return runtimeCall("box", n, t)
+	}
+
+	if !canBeNil(from) || notNil(n) {
+		return n
+	}
+	t := typeInfo(from, f)
+	if isNil(t) {
+		return n
+	}
+	return &luau.ParenExpr{X: &luau.BinaryExpr{Left: n, Right: runtimeCall("typednil", t), Op: luau.OR}}
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Pointer)
+	return ok
+}
+
+// types whose zero value is nil, interfaces aside
+func canBeNil(t types.Type) bool {
+	switch t.Underlying().(type) {
+	case *types.Pointer, *types.Slice, *types.Map, *types.Signature, *types.Chan:
+		return true
+	}
+	return false
+}
+
+// expressions which are never nil, literals and the values made by the runtime
+func notNil(n luau.Node) bool {
+	switch n := n.(type) {
+	case *luau.TableLit, *luau.FuncLit:
+		return true
+	case *luau.CallExpr:
+		switch fn := n.Fun.(type) {
+		case *luau.Ident:
+			return fn.Name == "setmetatable"
+		case *luau.SelectorExpr:
+			if x, ok := fn.X.(*luau.Ident); ok && x.Name == "GO" {
+				switch fn.Sel.Name {
+				case "newslice", "makeslice", "makechan", "bytes", "runeslice":
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// interfaces, type parameters excluded since they stand for the types they're instantiated with
+func isInterface(t types.Type) bool {
+	if _, ok := t.(*types.TypeParam); ok {
+		return false
+	}
+	return types.IsInterface(t)
+}
+
+// expressions assigned to the variables of a tuple, by position
+// ex: the arguments of a call, the results of a return statement
+func exprsAs(list []ast.Expr, vars *types.Tuple, variadic bool, f *File) ([]luau.Node, error) {
+	res := make([]luau.Node, len(list))
+	for i, e := range list {
+		var t types.Type
+		switch {
+		case vars == nil:
+		case variadic && i >= vars.Len()-1:
+			if s, ok := vars.At(vars.Len() - 1).Type().Underlying().(*types.Slice); ok {
+				t = s.Elem()
+			}
+		case i < vars.Len():
+			t = vars.At(i).Type()
+		}
+
+		n, err := ExprAs(e, t, f)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = n
+	}
+	return res, nil
+}
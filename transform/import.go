@@ -0,0 +1,262 @@
+package transform
+
+import (
+	"go/ast"
+	"go/types"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// TransformPath is the import path of this package,
+// which only exists at compile time and is never imported by generated code
+const TransformPath = "github.com/intervinn/abq/transform"
+
+var versionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// guess the name of a package which couldn't be type checked,
+// ex: github.com/foo/go-bar/v2 -> bar, gopkg.in/yaml.v3 -> yaml
+func guessName(p string) string {
+	parts := strings.Split(p, "/")
+	name := parts[len(parts)-1]
+	if versionSuffix.MatchString(name) && len(parts) > 1 {
+		name = parts[len(parts)-2]
+	}
+
+	if i := strings.Index(name, ".v"); i > 0 && versionSuffix.MatchString(name[i+1:]) {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(name, "-go")
+	return strings.NewReplacer("-", "_", ".", "_").Replace(name)
+}
+
+func importPath(i *ast.ImportSpec) string {
+	p, err := strconv.Unquote(i.Path.Value)
+	if err != nil {
+		return i.Path.Value
+	}
+	return p
+}
+
+// package imported by the spec, nil if unknown
+func (f *File) importedPkg(i *ast.ImportSpec) *types.PkgName {
+	return f.Pkg.importedPkg(i)
+}
+
+func (p *Package) importedPkg(i *ast.ImportSpec) *types.PkgName {
+	if i.Name != nil {
+		pn, _ := p.Info.Defs[i.Name].(*types.PkgName)
+		return pn
+	}
+	pn, _ := p.Info.Implicits[i].(*types.PkgName)
+	return pn
+}
+
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// nameImports picks the locals holding the imported modules.
+// Imports are scoped to a file, while the locals are shared by the files of the package,
+// so an import keeps its name only if every file imports the path under it
+// and no other path or top-level declaration goes by it,
+// otherwise the local is named after the path
+// ex: import rand "strings" -> local __strings = GO.import("strings")
+func (p *Package) nameImports() {
+	names := map[string]map[string]bool{} // local names of each path
+	paths := map[string]map[string]bool{} // paths imported under each name
+	for _, af := range p.Files {
+		for _, i := range af.Imports {
+			pn := p.importedPkg(i)
+			if pn == nil || pn.Name() == "_" || pn.Name() == "." {
+				continue
+			}
+			path, name := pn.Imported().Path(), importName(i, pn)
+			if names[path] == nil {
+				names[path] = map[string]bool{}
+			}
+			if paths[name] == nil {
+				paths[name] = map[string]bool{}
+			}
+			names[path][name] = true
+			paths[name][path] = true
+		}
+	}
+
+	for path, ns := range names {
+		p.imports[path] = pathLocal(path)
+		if len(ns) != 1 {
+			continue
+		}
+		for name := range ns {
+			if len(paths[name]) == 1 && (p.Types == nil || p.Types.Scope().Lookup(name) == nil) {
+				p.imports[path] = Mangle(name)
+			}
+		}
+	}
+}
+
+// name a file refers to an imported package by.
+// Packages which couldn't be type checked are named after their paths by go/types,
+// while the source refers to them by the alias or the name of the package, guessed from the path
+// ex: import "github.com/foo/bar/v2" -> bar
+func importName(i *ast.ImportSpec, pn *types.PkgName) string {
+	if pn.Imported().Complete() || i.Name != nil {
+		return pn.Name()
+	}
+	return guessName(importPath(i))
+}
+
+// package an undefined identifier refers to,
+// nil unless it names an import of the file which couldn't be type checked
+// ex: bar.Do with import "github.com/foo/bar/v2"
+func (p *Package) unresolvedImport(af *ast.File, id *ast.Ident) *types.PkgName {
+	if p.Info.Uses[id] != nil || p.Info.Defs[id] != nil {
+		return nil
+	}
+	for _, i := range af.Imports {
+		pn := p.importedPkg(i)
+		if pn != nil && !pn.Imported().Complete() && importName(i, pn) == id.Name {
+			return pn
+		}
+	}
+	return nil
+}
+
+// local named after an import path
+// ex: math/rand -> __math_rand
+func pathLocal(p string) string {
+	return "__" + nonIdent.ReplaceAllString(p, "_")
+}
+
+// local holding the module a package name refers to
+func (f *File) pkgLocal(pn *types.PkgName) *luau.Ident {
+	if name, ok := f.Pkg.imports[pn.Imported().Path()]; ok {
+		return &luau.Ident{Name: name}
+	}
+	return &luau.Ident{Name: Mangle(pn.Name())}
+}
+
+// name of the local holding the imported module,
+// empty if the module is imported only for its side effects
+func importLocal(i *ast.ImportSpec, f *File) string {
+	p := importPath(i)
+	if p == TransformPath {
+		return ""
+	}
+
+	if i.Name != nil {
+		switch i.Name.Name {
+		case "_":
+			return ""
+		case ".":
+			return dotImportName(p)
+		}
+	}
+
+	pn := f.importedPkg(i)
+	if pn == nil {
+		if i.Name != nil {
+			return Mangle(i.Name.Name)
+		}
+		return Mangle(guessName(p))
+	}
+
+	// imported packages which are never referred to
+	if !f.Pkg.usedPkgs[pn] {
+		return ""
+	}
+	return f.pkgLocal(pn).Name
+}
+
+// local holding a dot imported module
+func dotImportName(p string) string {
+	return pathLocal(p)
+}
+
+// ImportSpec requires the imported module into a local named after the package.
+// Blank and unused imports are still required for their side effects,
+// while the transform package is not imported at all
+// ex: local fmt = GO.import("fmt")
+func ImportSpec(i *ast.ImportSpec, f *File) (luau.Node, error) {
+	p := importPath(i)
+	if p == TransformPath {
+		return &luau.Block{}, nil
+	}
+
+	call := &luau.CallExpr{
+		Args: []luau.Node{&luau.StringLit{Value: p}},
+		Fun:  runtimeFunc("import"),
+	}
+
+	name := importLocal(i, f)
+	if name == "" {
+		// packages used only through members mapped onto Luau have no shim to load
+		if pn := f.importedPkg(i); pn != nil && f.Pkg.mappedPkgs[pn] {
+			return &luau.Block{}, nil
+		}
+		return &luau.ExprStmt{X: call}, nil
+	}
+
+	return &luau.DeclStmt{
+		Scope:  luau.LOCAL,
+		Names:  []luau.Node{&luau.Ident{Name: name}},
+		Values: []luau.Node{call},
+	}, nil
+}
+
+// module table of another package, nil if it's not imported by the file
+// ex: local fmt = GO.import("fmt")
+func (f *File) pkgRef(pkg *types.Package) luau.Node {
+	for _, i := range f.Imports {
+		if importPath(i) != pkg.Path() {
+			continue
+		}
+		if name := importLocal(i, f); name != "" {
+			return &luau.Ident{Name: name}
+		}
+	}
+	return nil
+}
+
+// check if the identifier refers to a top-level object of another package,
+// which happens with dot imports
+func (f *File) foreign(i *ast.Ident) (types.Object, bool) {
+	obj := f.Pkg.Info.Uses[i]
+	if obj == nil || obj.Pkg() == nil || obj.Pkg() == f.Pkg.Types {
+		return nil, false
+	}
+	if obj.Parent() != obj.Pkg().Scope() {
+		return nil, false
+	}
+	return obj, true
+}
+
+// package selector, the selected name is the key of the imported export table
+// ex: fmt.Println
+func (f *File) isPkgSelector(s *ast.SelectorExpr) bool {
+	return f.selectedPkg(s) != nil
+}
+
+// package selected from, nil if the selector isn't qualified by a package name
+func (f *File) selectedPkg(s *ast.SelectorExpr) *types.PkgName {
+	id, ok := s.X.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	if pn, ok := f.Pkg.Info.Uses[id].(*types.PkgName); ok {
+		return pn
+	}
+	return f.Pkg.unresolvedImport(f.File, id)
+}
+
+// exported name of another package, selected from its module
+// ex: fmt.Println
+func importedName(pkg *types.Package, name string, f *File) luau.Node {
+	x := f.pkgRef(pkg)
+	if x == nil {
+		x = &luau.Ident{Name: Mangle(pkg.Name())}
+	}
+	return &luau.SelectorExpr{X: x, Sel: &luau.Ident{Name: name}}
+}
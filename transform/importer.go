@@ -0,0 +1,101 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+var stdImporter = importer.Default()
+
+// moduleImporter imports the packages a package found in dir depends on.
+// Packages of modules are type checked from source, the go command resolves them
+// against the module holding dir rather than the working directory
+type moduleImporter struct {
+	dir  string
+	fset *token.FileSet
+	pkgs map[string]*types.Package
+}
+
+func newModuleImporter(dir string) *moduleImporter {
+	return &moduleImporter{
+		dir:  dir,
+		fset: token.NewFileSet(),
+		pkgs: map[string]*types.Package{},
+	}
+}
+
+func (m *moduleImporter) Import(path string) (*types.Package, error) {
+	return m.ImportFrom(path, m.dir, 0)
+}
+
+func (m *moduleImporter) ImportFrom(path string, dir string, mode types.ImportMode) (*types.Package, error) {
+	if isStd(path) {
+		if pkg, err := stdImporter.Import(path); err == nil {
+			return pkg, nil
+		}
+	}
+
+	// files parsed by their base names are relative to the package
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(m.dir, dir)
+	}
+
+	ctxt := build.Default
+	ctxt.Dir = m.dir
+	bp, err := ctxt.Import(path, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg, ok := m.pkgs[bp.ImportPath]; ok {
+		if pkg == nil || !pkg.Complete() {
+			return nil, fmt.Errorf("import cycle through package %q", bp.ImportPath)
+		}
+		return pkg, nil
+	}
+	m.pkgs[bp.ImportPath] = nil
+
+	files := []*ast.File{}
+	for _, name := range bp.GoFiles {
+		f, err := parser.ParseFile(m.fset, filepath.Join(bp.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	// only the declarations of dependencies are needed
+	errs := []error{}
+	conf := types.Config{
+		Importer:         m,
+		IgnoreFuncBodies: true,
+		FakeImportC:      true,
+		Error:            func(err error) { errs = append(errs, err) },
+	}
+	pkg, _ := conf.Check(bp.ImportPath, m.fset, files, nil)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("type checking package %q failed: %w", bp.ImportPath, errors.Join(errs...))
+	}
+
+	m.pkgs[bp.ImportPath] = pkg
+	return pkg, nil
+}
+
+// directory of the files of a package, the working directory for files without one
+func packageDir(fset *token.FileSet, files []*ast.File) string {
+	dir := "."
+	if len(files) > 0 {
+		dir = filepath.Dir(fset.File(files[0].Pos()).Name())
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
@@ -0,0 +1,49 @@
+package transform
+
+import "strings"
+
+// names which can't be used as Luau identifiers,
+// or would shadow globals the generated code and the runtime depend on
+var reserved = map[string]bool{
+	// keywords
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true, "continue": true, "export": true, "type": true,
+
+	// globals
+	"assert": true, "error": true, "getmetatable": true, "ipairs": true,
+	"next": true, "pairs": true, "pcall": true, "print": true, "rawequal": true,
+	"rawget": true, "rawlen": true, "rawset": true, "require": true,
+	"select": true, "setmetatable": true, "tonumber": true, "tostring": true,
+	"typeof": true, "unpack": true, "xpcall": true, "newproxy": true,
+	"warn": true, "self": true, "_G": true, "_VERSION": true,
+
+	// libraries
+	"bit32": true, "buffer": true, "coroutine": true, "debug": true,
+	"math": true, "os": true, "string": true, "table": true, "utf8": true,
+
+	// roblox
+	"game": true, "workspace": true, "script": true, "shared": true,
+	"plugin": true, "task": true, "Enum": true, "Instance": true,
+	"DateTime": true, "Random": true,
+
+	// runtime
+	"GO": true,
+}
+
+// Mangle escapes Go identifiers which are reserved in Luau
+// or clash with the names of generated locals (prefixed by "__").
+// An underscore is appended to them, as well as to the names already ending with one,
+// so that no two Go names map to the same Luau name
+// ex: end -> end_, end_ -> end__
+func Mangle(name string) string {
+	if name == "_" {
+		return name
+	}
+	if reserved[name] || strings.HasSuffix(name, "_") || strings.HasPrefix(name, "__") {
+		return name + "_"
+	}
+	return name
+}
@@ -1,9 +1,13 @@
 package pack
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 
 	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
@@ -11,7 +15,7 @@ import (
 
 /*
 	/shared/things.go -> /shared/go_include/github.com/.../shared/things.go
-	github.com/gofiber/fiber -> /shared/go_include/github.com/fiber
+	github.com/gofiber/fiber -> /shared/go_include/github.com/gofiber/fiber
 */
 
 func ReadModfile(src string) (*modfile.File, error) {
@@ -24,6 +28,36 @@ func ReadModfile(src string) (*modfile.File, error) {
 	return mod, err
 }
 
+// ModuleImportPath is the import path of the package in dir, the module path of the closest go.mod
+// followed by the directory relative to it. Directories outside of modules have no import path
+func ModuleImportPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for root := dir; ; root = filepath.Dir(root) {
+		mod, err := ReadModfile(filepath.Join(root, "go.mod"))
+		if err == nil {
+			if mod.Module == nil {
+				return "", fmt.Errorf("%s: no module declaration", filepath.Join(root, "go.mod"))
+			}
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return "", err
+			}
+			return path.Join(mod.Module.Mod.Path, filepath.ToSlash(rel)), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+
+		if filepath.Dir(root) == root {
+			return "", nil
+		}
+	}
+}
+
 func ModPath(name, version string) (string, error) {
 	cache, ok := os.LookupEnv("GOMODCACHE")
 	if !ok {
@@ -52,9 +86,10 @@ func ResolveImports(mod *modfile.File, out string) ([]*Pack, error) {
 		}
 
 		log.Printf("building module %s...\n", r.Mod.String())
-		p := NewPack(path.Join(out, r.Mod.Path))
-
-		err = p.Dir(modPath)
+		// modules in the cache are imported by their required path,
+		// whether or not they have a go.mod
+		p := NewPack(out)
+		err = p.tree(modPath, r.Mod.Path, path.Join(out, r.Mod.Path))
 		if err != nil {
 			return nil, err
 		}
@@ -2,6 +2,8 @@ package pack
 
 import (
 	"fmt"
+	"go/ast"
+	"go/token"
 	"io"
 	"log"
 	"os"
@@ -22,6 +24,8 @@ type Pack struct {
 	Package  string
 	Assembly []*luau.File
 	Out      string // outdir root
+	Runtime  string // runtime folder
+	Shared   string // folder mapped onto ReplicatedStorage, empty outside of rojo projects
 }
 
 func (p *Pack) Add(p2 *Pack) {
@@ -41,31 +45,37 @@ func (p *Pack) Rojo(root string, out string) error {
 		return err
 	}
 
+	// packages are rendered at their import paths under go_include,
+	// server and client packages are only seen from where they run
 	log.Println("building server")
-	server := NewPack(path.Join(out))
-	if err = server.Dir(path.Join(root, "server")); err != nil {
+	server := NewPack(path.Join(out, "server", IncludeDir))
+	if err = server.Tree(path.Join(root, "server"), server.Out); err != nil {
 		return fmt.Errorf("failed to build server: %v", err)
 	}
 
 	log.Println("building client")
-	client := NewPack(path.Join(out))
-	if err = client.Dir(path.Join(root, "client")); err != nil {
+	client := NewPack(path.Join(out, "client", IncludeDir))
+	if err = client.Tree(path.Join(root, "client"), client.Out); err != nil {
 		return fmt.Errorf("failed to build client: %v", err)
 	}
 
 	log.Println("building shared")
-	shared := NewPack(path.Join(out, "shared", "go_include", mod.Module.Mod.Path))
-	if err = shared.Dir(path.Join(root, "shared")); err != nil {
+	shared := NewPack(path.Join(out, "shared", IncludeDir))
+	if err = shared.Tree(path.Join(root, "shared"), shared.Out); err != nil {
 		return fmt.Errorf("failed to build shared: %v", err)
 	}
 
 	log.Println("resolving imports")
-	imports, err := ResolveImports(mod, path.Join(out, "shared", "go_include"))
+	imports, err := ResolveImports(mod, path.Join(out, "shared", IncludeDir))
 
 	if err != nil {
 		return fmt.Errorf("failed to resolve imports: %v", err)
 	}
 
+	// the runtime is shared by server and client
+	p.Shared = path.Join(out, "shared")
+	p.Runtime = path.Join(p.Shared, RuntimeDir)
+
 	log.Println("connecting assemblies...")
 	p.Add(server)
 	p.Add(client)
@@ -89,7 +99,8 @@ func (pc *Pack) Assembled(name string) *luau.File {
 
 func NewPack(out string) *Pack {
 	return &Pack{
-		Out: out,
+		Out:     out,
+		Runtime: path.Join(out, RuntimeDir),
 	}
 }
 
@@ -101,7 +112,33 @@ func (pc *Pack) File(p string) (string, error) {
 	return string(src), nil
 }
 
-func (pc *Pack) Dir(p string) error {
+// assembly rendered into out, created if missing
+func (pc *Pack) assembly(name string, out string) *luau.File {
+	for _, v := range pc.Assembly {
+		if v.Out == out {
+			return v
+		}
+	}
+
+	asm := luau.NewFile(name, out)
+	pc.Assembly = append(pc.Assembly, asm)
+	return asm
+}
+
+// Tree builds the packages under p, rendering each at its import path under out,
+// which is the module path of the go.mod holding p followed by the package's directory
+// ex: mod/util -> out/mod/util
+func (pc *Pack) Tree(p string, out string) error {
+	importPath, err := ModuleImportPath(p)
+	if err != nil {
+		return err
+	}
+	return pc.tree(p, importPath, path.Join(out, importPath))
+}
+
+// tree builds the packages under p into out, the package in p is imported by importPath.
+// Packages outside of modules have no import path and go by their names
+func (pc *Pack) tree(p string, importPath string, out string) error {
 	dir := path.Base(p)
 	if slices.Contains(Except, dir) {
 		return nil
@@ -112,54 +149,68 @@ func (pc *Pack) Dir(p string) error {
 		return err
 	}
 
+	// files of the package are transformed together
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+
 	for _, e := range entries {
 		if e.IsDir() {
 			if slices.Contains(Except, e.Name()) {
 				continue
 			}
-			err = pc.Dir(path.Join(p, e.Name()))
+			sub := ""
+			if importPath != "" {
+				sub = path.Join(importPath, e.Name())
+			}
+			err = pc.tree(path.Join(p, e.Name()), sub, path.Join(out, e.Name()))
 			if err != nil {
 				return err
 			}
 		}
 
-		if strings.HasSuffix(e.Name(), ".go") {
-			asm := pc.Assembled(dir)
-			if asm == nil {
-				asm = luau.NewFile(dir, path.Join(pc.Out, dir))
-				pc.Assembly = append(pc.Assembly, asm)
-			}
-
+		if strings.HasSuffix(e.Name(), ".go") && !strings.HasSuffix(e.Name(), "_test.go") {
 			str, err := pc.File(path.Join(p, e.Name()))
 			if err != nil {
 				return err
 			}
 
-			log.Printf("building %s", e.Name())
-			src, err := transform.Source(e.Name(), str)
+			log.Printf("parsing %s", e.Name())
+			f, err := transform.Parse(fset, path.Join(p, e.Name()), str)
 			if err != nil {
-				log.Printf("file %v failed to build\n", e.Name())
+				log.Printf("file %v failed to parse\n", e.Name())
 				return err
 			}
-			asm.Decls = append(asm.Decls, src...)
+			files = append(files, f)
 		}
 
 		// If a folder contains Luau file - just move it to out folder
 		if strings.HasSuffix(e.Name(), ".luau") {
-			asm := pc.Assembled(dir)
-			if asm == nil {
-				asm = luau.NewFile(dir, path.Join(pc.Out, dir))
-				pc.Assembly = append(pc.Assembly, asm)
-			}
-
+			asm := pc.assembly(dir, out)
 			asm.Include = append(asm.Include, path.Join(p, e.Name()))
 		}
 	}
 
+	if len(files) == 0 {
+		return nil
+	}
+
+	asm := pc.assembly(dir, out)
+
+	log.Printf("building %s", dir)
+	src, err := transform.Files(fset, importPath, files)
+	if err != nil {
+		log.Printf("package %v failed to build\n", dir)
+		return err
+	}
+	asm.Decls = append(asm.Decls, src...)
+
 	return nil
 }
 
 func (p *Pack) Render() error {
+	if err := p.RenderRuntime(); err != nil {
+		return err
+	}
 
 	for _, a := range p.Assembly {
 		root := a.Out
@@ -169,11 +220,11 @@ func (p *Pack) Render() error {
 		}
 
 		// init.luau
-
-		// final transformations
-
-		// TODO: move in a more suitable place
-		a.Decls = append(a.Decls, transform.Exports(a)) // export table
+		prologue, err := p.Prologue(a)
+		if err != nil {
+			return err
+		}
+		a.Decls = append([]luau.Node{prologue}, a.Decls...)
 
 		// create file
 		init, err := os.Create(path.Join(root, "init.luau"))
@@ -209,7 +260,9 @@ func (p *Pack) Render() error {
 				return err
 			}
 
-			return dst.Sync()
+			if err = dst.Sync(); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,151 @@
+package pack
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intervinn/abq/luau"
+)
+
+func TestRequirePath(t *testing.T) {
+	cases := map[[2]string]string{
+		{"out/game", "out/GO"}:                         `script.Parent["GO"]`,
+		{"out/shared/go_include/a/b", "out/shared/GO"}: `script.Parent.Parent.Parent["GO"]`,
+		{"out", "out/GO"}:                              `script["GO"]`,
+	}
+
+	for c, want := range cases {
+		got, err := RequirePath(c[0], c[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("RequirePath(%q, %q) = %s, want %s", c[0], c[1], got, want)
+		}
+	}
+}
+
+func TestRojoRuntimePath(t *testing.T) {
+	p := &Pack{Shared: "out/shared", Runtime: "out/shared/GO"}
+
+	cases := map[string]string{
+		"out/server":                  `game:GetService("ReplicatedStorage")["GO"]`,
+		"out/client/ui":               `game:GetService("ReplicatedStorage")["GO"]`,
+		"out/shared/go_include/a/b":   `script.Parent.Parent.Parent["GO"]`,
+		"out/shared/go_include/a/b/c": `script.Parent.Parent.Parent.Parent["GO"]`,
+	}
+
+	for from, want := range cases {
+		got, err := p.runtimePath(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("runtimePath(%q) = %s, want %s", from, got, want)
+		}
+	}
+}
+
+func TestRenderRuntime(t *testing.T) {
+	out := t.TempDir()
+	p := NewPack(out)
+	if err := p.RenderRuntime(); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := os.ReadFile(path.Join(out, RuntimeDir, "init.luau"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "function go.import") {
+		t.Error("runtime module is missing go.import")
+	}
+
+	// standard library shims keep their import path under std
+	for pkg, fn := range map[string]string{
+		"fmt":           "fmt.Printf",
+		"strings":       "strings.Split",
+		"strconv":       "strconv.Atoi",
+		"unicode/utf8":  "utf8.RuneCountInString",
+		"math":          "gomath.Inf",
+		"math/bits":     "bits.OnesCount64",
+		"math/rand":     "rand.NewSource",
+		"sort":          "sort.Slice",
+		"slices":        "slices.Sort",
+		"maps":          "maps.Keys",
+		"cmp":           "cmp.Compare",
+		"errors":        "errors.As",
+		"time":          "time.NewTicker",
+		"sync":          "WaitGroup.Wait",
+		"sync/atomic":   "Value.Store",
+		"context":       "context.WithTimeout",
+		"encoding/json": "json.Unmarshal",
+		"log":           "log.New",
+		"reflect":       "Value.SetInt",
+	} {
+		src, err := os.ReadFile(path.Join(out, RuntimeDir, "std", pkg, "init.luau"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(src), "function "+fn) {
+			t.Errorf("%s shim is missing %s", pkg, fn)
+		}
+	}
+}
+
+func TestTreeImportPaths(t *testing.T) {
+	t.Setenv("GOPROXY", "off")
+	root := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod":       "module example.com/game\n\ngo 1.23\n",
+		"main.go":      "package main\n\nimport (\n\t\"example.com/game/a/util\"\n\tb \"example.com/game/b/util\"\n)\n\nfunc main() {\n\t_ = util.T{}\n\t_ = b.T{}\n}\n",
+		"a/util/a.go":  "package util\n\ntype T struct{ A int }\n",
+		"b/util/b.go":  "package util\n\ntype T struct{ B int }\n",
+		"c/ignored.md": "",
+	} {
+		p := path.Join(root, name)
+		if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := t.TempDir()
+	p := NewPack(out)
+	if err := p.Tree(root, out); err != nil {
+		t.Fatal(err)
+	}
+
+	// every package is rendered at its import path, and registers its types under it
+	for dir, want := range map[string][]string{
+		"example.com/game": {
+			`GO.import("example.com/game/a/util")`,
+			`GO.import("example.com/game/b/util")`,
+		},
+		"example.com/game/a/util": {`path = "example.com/game/a/util"`},
+		"example.com/game/b/util": {`path = "example.com/game/b/util"`},
+	} {
+		var asm *luau.File
+		for _, a := range p.Assembly {
+			if a.Out == path.Join(out, dir) {
+				asm = a
+			}
+		}
+		if asm == nil {
+			t.Errorf("package %s isn't rendered at %s", dir, path.Join(out, dir))
+			continue
+		}
+
+		w := luau.NewStringWriter()
+		asm.Render(w)
+		for _, s := range want {
+			if !strings.Contains(w.Content, s) {
+				t.Errorf("%s: missing %q in\n%s", dir, s, w.Content)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package pack
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/intervinn/abq/luau"
+	"github.com/intervinn/abq/runtime"
+)
+
+// RuntimeDir is the default name of the runtime folder in the output root
+const RuntimeDir = "GO"
+
+// IncludeDir holds shared packages and dependencies, each at its import path
+const IncludeDir = "go_include"
+
+// RequirePath builds the instance path of the module rendered into to,
+// relative to the module rendered into from
+// ex: script.Parent.Parent["GO"]
+func RequirePath(from string, to string) (string, error) {
+	rel, err := filepath.Rel(from, to)
+	if err != nil {
+		return "", err
+	}
+
+	res := "script"
+	for _, seg := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch seg {
+		case ".":
+		case "..":
+			res += ".Parent"
+		default:
+			res += "[" + strconv.Quote(seg) + "]"
+		}
+	}
+	return res, nil
+}
+
+// runtimePath builds the instance path of the runtime as seen from a module rendered into from.
+// The folders of a rojo project aren't instances, only their contents are,
+// so modules outside of the shared folder find the runtime in ReplicatedStorage
+// ex: game:GetService("ReplicatedStorage")["GO"]
+func (p *Pack) runtimePath(from string) (string, error) {
+	if p.Shared == "" {
+		return RequirePath(from, p.Runtime)
+	}
+
+	rel, err := filepath.Rel(p.Shared, from)
+	if err != nil {
+		return "", err
+	}
+	if rel != ".." && !strings.HasPrefix(filepath.ToSlash(rel), "../") {
+		return RequirePath(from, p.Runtime)
+	}
+
+	req, err := RequirePath(p.Shared, p.Runtime)
+	if err != nil {
+		return "", err
+	}
+	return `game:GetService("ReplicatedStorage")` + strings.TrimPrefix(req, "script"), nil
+}
+
+// Prologue requires the runtime into every module
+// ex: local GO = require(script.Parent["GO"])
+func (p *Pack) Prologue(a *luau.File) (*luau.Raw, error) {
+	req, err := p.runtimePath(a.Out)
+	if err != nil {
+		return nil, err
+	}
+
+	return &luau.Raw{
+		Content: "local GO = require(" + req + ")",
+	}, nil
+}
+
+// RenderRuntime writes the embedded runtime into the runtime folder
+func (p *Pack) RenderRuntime() error {
+	return fs.WalkDir(runtime.Files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		src, err := runtime.Files.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		dst := path.Join(p.Runtime, name)
+		if name == runtime.Main {
+			dst = path.Join(p.Runtime, "init.luau")
+		}
+
+		if err := os.MkdirAll(path.Dir(dst), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, src, 0600)
+	})
+}
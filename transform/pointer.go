@@ -0,0 +1,210 @@
+package transform
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// name of the field holding the value of a box
+const boxField = "v"
+
+// escapes marks the variables of a file which have their address taken.
+// Such variables are stored in a box, a table { v = value },
+// so that every pointer to the variable shares the same storage
+func (p *Package) escapes(file *ast.File) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				p.markBoxed(n.X)
+			}
+		case *ast.SelectorExpr:
+			// calling a pointer method on a value takes its address implicitly
+			if implicitAddr(p.Info, n) {
+				p.markBoxed(n.X)
+			}
+		}
+		return true
+	})
+}
+
+func (p *Package) markBoxed(x ast.Expr) {
+	id, ok := ast.Unparen(x).(*ast.Ident)
+	if !ok {
+		return
+	}
+
+	v, ok := p.Info.ObjectOf(id).(*types.Var)
+	if !ok || isAggregate(v.Type()) {
+		return
+	}
+	p.boxed[v] = true
+}
+
+// check if the selector is a pointer method called on a non-pointer value
+func implicitAddr(info *types.Info, s *ast.SelectorExpr) bool {
+	sel, ok := info.Selections[s]
+	if !ok || sel.Kind() != types.MethodVal || sel.Indirect() {
+		return false
+	}
+
+	sig := sel.Obj().Type().(*types.Signature)
+	if sig.Recv() == nil {
+		return false
+	}
+	if _, ok := sig.Recv().Type().(*types.Pointer); !ok {
+		return false
+	}
+	_, ok = sel.Recv().Underlying().(*types.Pointer)
+	return !ok
+}
+
+// check if the selector is a value method called on a pointer
+func implicitDeref(info *types.Info, s *ast.SelectorExpr) bool {
+	sel, ok := info.Selections[s]
+	if !ok || sel.Kind() != types.MethodVal || len(sel.Index()) != 1 {
+		return false
+	}
+
+	sig := sel.Obj().Type().(*types.Signature)
+	if sig.Recv() == nil {
+		return false
+	}
+	if _, ok := sig.Recv().Type().(*types.Pointer); ok {
+		return false
+	}
+	_, ok = sel.Recv().Underlying().(*types.Pointer)
+	return ok
+}
+
+func (f *File) isBoxed(i *ast.Ident) bool {
+	obj := f.objectOf(i)
+	return obj != nil && f.Pkg.boxed[obj]
+}
+
+// box of a variable of type t, pointers to named types other than structs
+// carry the type so that they have its methods when stored in interfaces
+// ex: {v = x}, GO.pointer(c, Celsius)
+func box(value luau.Node, t types.Type, f *File) luau.Node {
+	if n, ok := t.(*types.Named); ok && n.NumMethods() > 0 && !types.IsInterface(n) {
+		if pkg := n.Obj().Pkg(); pkg == f.Pkg.Types || pkg != nil && !isStd(pkg.Path()) {
+			return runtimeCall("pointer", value, typeName(n, f))
+		}
+	}
+
+	return &luau.TableLit{
+		Elts: []luau.Node{
+			&luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: boxField},
+				Value: value,
+			},
+		},
+	}
+}
+
+func unbox(x luau.Node) *luau.SelectorExpr {
+	return &luau.SelectorExpr{
+		X:   x,
+		Sel: &luau.Ident{Name: boxField},
+	}
+}
+
+// boxing statements for freshly declared variables
+// ex: x = {v = x}
+func boxVars(names []*ast.Ident, f *File) []luau.Node {
+	res := []luau.Node{}
+	for _, n := range names {
+		if n.Name == "_" || !f.isBoxed(n) {
+			continue
+		}
+
+		id := Ident(n, f)
+		res = append(res, &luau.AssignStmt{
+			Left:  []luau.Node{id},
+			Right: []luau.Node{box(id, f.objectOf(n).Type(), f)},
+		})
+	}
+	return res
+}
+
+// Identifier used as an expression, boxed variables are read through their box
+func IdentExpr(i *ast.Ident, f *File) luau.Node {
+	if obj, ok := f.foreign(i); ok {
+		if n, ok := stdMember(obj); ok {
+			return n
+		}
+		return importedName(obj.Pkg(), obj.Name(), f)
+	}
+
+	id := Ident(i, f)
+	if f.isBoxed(i) {
+		return unbox(id)
+	}
+	return id
+}
+
+// Dereference
+// ex: *p
+func StarExpr(s *ast.StarExpr, f *File) (luau.Node, error) {
+	x, err := Expr(s.X, f)
+	if err != nil {
+		return nil, err
+	}
+
+	t := elem(f.typeOf(s.X))
+	if t == nil || isAggregate(t) {
+		return x, nil
+	}
+	return unbox(x), nil
+}
+
+// AddrOf transforms &x.
+// Boxed variables are their own pointers, structs and arrays are references already,
+// fields and elements are referenced with GO.ref(table, key)
+func AddrOf(x ast.Expr, f *File) (luau.Node, error) {
+	x = ast.Unparen(x)
+
+	switch e := x.(type) {
+	case *ast.Ident:
+		if f.isBoxed(e) {
+			return Ident(e, f), nil
+		}
+	case *ast.StarExpr:
+		return Expr(e.X, f)
+	case *ast.SelectorExpr:
+		if t := f.typeOf(e); t == nil || isAggregate(t) {
+			break
+		}
+
+		n, err := SelectorExpr(e, f)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := n.(*luau.SelectorExpr); ok {
+			return ref(s.X, &luau.StringLit{Value: s.Sel.Name}), nil
+		}
+		return n, nil
+	case *ast.IndexExpr:
+		if t := f.typeOf(e); t == nil || isAggregate(t) {
+			break
+		}
+
+		i, err := IndexExpr(e, f)
+		if err != nil {
+			return nil, err
+		}
+		return ref(i.X, i.Index), nil
+	}
+
+	return Expr(x, f)
+}
+
+func ref(t luau.Node, key luau.Node) *luau.CallExpr {
+	return &luau.CallExpr{
+		Fun:  runtimeFunc("ref"),
+		Args: []luau.Node{t, key},
+	}
+}
@@ -0,0 +1,173 @@
+package transform
+
+import (
+	"go/ast"
+	"go/build"
+	"go/constant"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// members of standard packages which map directly onto Luau's libraries,
+// the rest is left to the shims of the runtime
+var stdlib = map[string]map[string]string{
+	"math": {
+		"Abs":   "math.abs",
+		"Acos":  "math.acos",
+		"Asin":  "math.asin",
+		"Atan":  "math.atan",
+		"Atan2": "math.atan2",
+		"Ceil":  "math.ceil",
+		"Cos":   "math.cos",
+		"Cosh":  "math.cosh",
+		"Exp":   "math.exp",
+		"Floor": "math.floor",
+		"Frexp": "math.frexp",
+		"Ldexp": "math.ldexp",
+		"Log":   "math.log",
+		"Log10": "math.log10",
+		"Max":   "math.max",
+		"Min":   "math.min",
+		"Mod":   "math.fmod",
+		"Modf":  "math.modf",
+		"Pow":   "math.pow",
+		"Round": "math.round",
+		"Sin":   "math.sin",
+		"Sinh":  "math.sinh",
+		"Sqrt":  "math.sqrt",
+		"Tan":   "math.tan",
+		"Tanh":  "math.tanh",
+	},
+	"math/bits": {
+		"LeadingZeros32":  "bit32.countlz",
+		"TrailingZeros32": "bit32.countrz",
+		"RotateLeft32":    "bit32.lrotate",
+		"ReverseBytes32":  "bit32.byteswap",
+	},
+}
+
+// import paths known to be in the standard library or not
+var stdPaths sync.Map
+
+// packages of the standard library are the ones found in GOROOT,
+// the shape of a path tells nothing as modules and packages outside of them may have no dots
+func isStd(path string) bool {
+	if std, ok := stdPaths.Load(path); ok {
+		return std.(bool)
+	}
+
+	std := false
+	if path != "" && !build.IsLocalImport(path) && !filepath.IsAbs(path) {
+		info, err := os.Stat(filepath.Join(build.Default.GOROOT, "src", filepath.FromSlash(path)))
+		std = err == nil && info.IsDir()
+	}
+	stdPaths.Store(path, std)
+	return std
+}
+
+// stdMember maps a member of a standard package onto Luau,
+// constants are inlined and functions of stdlib name their Luau equivalent
+// ex: math.Pi -> 3.141592653589793, math.Floor -> math.floor
+func stdMember(obj types.Object) (luau.Node, bool) {
+	if obj == nil || obj.Pkg() == nil || !isStd(obj.Pkg().Path()) {
+		return nil, false
+	}
+
+	if c, ok := obj.(*types.Const); ok {
+		return constLit(c.Val())
+	}
+
+	if _, ok := obj.(*types.Func); !ok {
+		return nil, false
+	}
+	name, ok := stdlib[obj.Pkg().Path()][obj.Name()]
+	if !ok {
+		return nil, false
+	}
+
+	parts := strings.Split(name, ".")
+	var n luau.Node = &luau.Ident{Name: parts[0]}
+	for _, p := range parts[1:] {
+		n = &luau.SelectorExpr{X: n, Sel: &luau.Ident{Name: p}}
+	}
+	return n, true
+}
+
+// package path and name of a called function of a standard package, empty otherwise
+// ex: errors.As
+func stdFunc(c *ast.CallExpr, f *File) string {
+	sel, ok := ast.Unparen(c.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	fn, ok := f.Pkg.Info.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil || !isStd(fn.Pkg().Path()) || fn.Signature().Recv() != nil {
+		return ""
+	}
+	return fn.Pkg().Path() + "." + fn.Name()
+}
+
+// stdCallArgs are extra arguments of standard functions which need static types at runtime
+// ex: errors.As(err, &target) -> errors.As(err, target, PathError.__type)
+func stdCallArgs(c *ast.CallExpr, f *File) []luau.Node {
+	switch stdFunc(c, f) {
+	case "errors.As":
+		if len(c.Args) == 2 {
+			return []luau.Node{typeDescriptor(elem(f.typeOf(c.Args[1])), f)}
+		}
+	case "encoding/json.Marshal", "encoding/json.MarshalIndent", "reflect.TypeOf", "reflect.ValueOf":
+		return []luau.Node{typeInfo(f.typeOf(c.Args[0]), f)}
+	case "encoding/json.Unmarshal":
+		if len(c.Args) == 2 {
+			return []luau.Node{typeInfo(f.typeOf(c.Args[1]), f)}
+		}
+	}
+	return nil
+}
+
+// fmtArgs boxes the unnamed floats passed to fmt with their type,
+// fmt would take integral ones for ints otherwise
+// ex: fmt.Printf("%T", 1.0) -> fmt.Printf("%T", GO.box(1, {kind = "float64"}))
+func fmtArgs(c *ast.CallExpr, args []luau.Node, f *File) {
+	if !strings.HasPrefix(stdFunc(c, f), "fmt.") || c.Ellipsis.IsValid() {
+		return
+	}
+	sig, ok := f.typeOf(c.Fun).(*types.Signature)
+	if !ok || !sig.Variadic() {
+		return
+	}
+
+	for i := sig.Params().Len() - 1; i < len(c.Args); i++ {
+		// constants with fractions are told apart as they are
+		if v := f.Pkg.Info.Types[c.Args[i]].Value; v != nil && constant.ToInt(v).Kind() != constant.Int {
+			continue
+		}
+		t, ok := types.Default(f.typeOf(c.Args[i])).(*types.Basic)
+		if ok && t.Info()&types.IsFloat != 0 {
+			args[i] = runtimeCall("box", args[i], typeInfo(t, f))
+		}
+	}
+}
+
+// literal of a constant value
+func constLit(v constant.Value) (luau.Node, bool) {
+	switch v.Kind() {
+	case constant.Bool:
+		return &luau.Ident{Name: strconv.FormatBool(constant.BoolVal(v))}, true
+	case constant.String:
+		q := strconv.Quote(constant.StringVal(v))
+		return &luau.StringLit{Value: q[1 : len(q)-1]}, true
+	case constant.Int:
+		return &luau.NumericLit{Value: v.ExactString()}, true
+	case constant.Float:
+		x, _ := constant.Float64Val(v)
+		return &luau.NumericLit{Value: strconv.FormatFloat(x, 'g', -1, 64)}, true
+	}
+	return nil, false
+}
@@ -6,67 +6,13 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
-	"path"
-	"strings"
-	"unicode"
+	"go/types"
+	"log"
+	"strconv"
 
 	"github.com/intervinn/abq/luau"
 )
 
-func addIdent(id *luau.Ident, lit *luau.TableLit) {
-	lit.Elts = append(lit.Elts, &luau.KeyValueExpr{
-		Key:   id,
-		Value: id,
-	})
-}
-
-func exportDecl(decl luau.Node) *luau.TableLit {
-	res := &luau.TableLit{}
-
-	switch d := decl.(type) {
-	default:
-		fmt.Printf("%#v\n", d)
-	case *luau.Block:
-		for _, v := range d.List {
-			rlit := exportDecl(v)
-			if rlit != nil {
-				res.Elts = append(res.Elts, rlit.Elts...)
-			}
-		}
-	case *luau.DeclStmt:
-		for _, n := range d.Names {
-			if id, ok := n.(*luau.Ident); ok && unicode.IsUpper(rune(id.Name[0])) {
-				addIdent(id, res)
-			}
-		}
-	case *luau.FuncStmt:
-		name := d.Name
-		if len(strings.Split(d.Name.Name, ".")) > 1 {
-			return nil
-		}
-		addIdent(name, res)
-	}
-	return res
-}
-
-// export top-level variables and funcs
-func Exports(f *luau.File) luau.Node {
-	lit := &luau.TableLit{
-		Elts: []luau.Node{},
-	}
-
-	for _, d := range f.Decls {
-		res := exportDecl(d)
-		if res != nil {
-			lit.Elts = append(lit.Elts, res.Elts...)
-		}
-	}
-
-	return &luau.ReturnStmt{
-		Results: []luau.Node{lit},
-	}
-}
-
 // transform.Mod is a reserved call expression
 // for rendering raw luau strings in where its written
 func Mod[T any](value string) T {
@@ -103,35 +49,70 @@ func Token(t token.Token) luau.Token {
 		return luau.REM
 	case token.REM_ASSIGN:
 		return luau.REM_ASSIGN
+	case token.LAND:
+		return luau.AND
+	case token.LOR:
+		return luau.OR
+	case token.NOT:
+		return luau.NOT
+	case token.EQL:
+		return luau.EQL
+	case token.NEQ:
+		return luau.NEQ
+	case token.LSS:
+		return luau.LSS
+	case token.GTR:
+		return luau.GTR
+	case token.LEQ:
+		return luau.LEQ
+	case token.GEQ:
+		return luau.GEQ
 	}
 	return luau.ILLEGAL
 }
 
 func Source(name string, src string) ([]luau.Node, error) {
-	f, err := Parse(name, src)
+	fset := token.NewFileSet()
+	af, err := Parse(fset, name, src)
 	if err != nil {
 		panic(err)
 	}
 
+	return Files(fset, "", []*ast.File{af})
+}
+
+// Files transforms the files of a single package found at an import path.
+// Type errors are logged, the package is transformed with what could be type checked
+func Files(fset *token.FileSet, path string, files []*ast.File) ([]luau.Node, error) {
+	pkg := NewPackage(fset, path, files)
+	for _, err := range pkg.Errors {
+		log.Printf("type error: %v", err)
+	}
+
 	res := []luau.Node{}
-	for _, d := range f.Decls {
-		decl, err := Decl(d, f)
-		if err != nil {
-			return nil, err
-		}
+	for _, af := range files {
+		f := NewFile(af, pkg)
+		for _, d := range f.Decls {
+			decls, err := TopDecl(d, f)
+			if err != nil {
+				return nil, err
+			}
 
-		res = append(res, decl)
+			res = append(res, decls...)
+		}
 	}
+
+	res = append(res, Exports(pkg))
 	return res, nil
 }
 
-func Parse(name string, src string) (*ast.File, error) {
-	return parser.ParseFile(token.NewFileSet(), name, src, parser.AllErrors)
+func Parse(fset *token.FileSet, name string, src string) (*ast.File, error) {
+	return parser.ParseFile(fset, name, src, parser.AllErrors)
 }
 
 var lastDecl ast.Decl
 
-func Decl(d ast.Decl, f *ast.File) (luau.Node, error) {
+func Decl(d ast.Decl, f *File) (luau.Node, error) {
 
 	switch decl := d.(type) {
 	case *ast.FuncDecl:
@@ -145,7 +126,7 @@ func Decl(d ast.Decl, f *ast.File) (luau.Node, error) {
 	return nil, fmt.Errorf("unknown declaration: %#v", d)
 }
 
-func GenDecl(g *ast.GenDecl, f *ast.File) (luau.Node, error) {
+func GenDecl(g *ast.GenDecl, f *File) (luau.Node, error) {
 	block := &luau.Block{}
 
 	for _, s := range g.Specs {
@@ -159,7 +140,7 @@ func GenDecl(g *ast.GenDecl, f *ast.File) (luau.Node, error) {
 	return block, nil
 }
 
-func Spec(s ast.Spec, f *ast.File) (luau.Node, error) {
+func Spec(s ast.Spec, f *File) (luau.Node, error) {
 	switch spec := s.(type) {
 	case *ast.ValueSpec:
 		return ValueSpec(spec, f)
@@ -171,47 +152,19 @@ func Spec(s ast.Spec, f *ast.File) (luau.Node, error) {
 	return nil, fmt.Errorf("unknown spec: %#v", s)
 }
 
-func ImportSpec(i *ast.ImportSpec, f *ast.File) (luau.Node, error) {
-	ident := i.Name
-	name := ""
-	if ident == nil {
-
-		name = path.Base(i.Path.Value)
-		name = name[:len(name)-1] // trim quote
-	} else {
-		name = ident.Name
-	}
-
-	return &luau.DeclStmt{
-		Scope: luau.LOCAL,
-		Names: []luau.Node{
-			&luau.Ident{
-				Name: name,
-			},
-		},
-		Values: []luau.Node{
-			&luau.CallExpr{
-				Args: []luau.Node{
-					&luau.Ident{Name: i.Path.Value[:len(i.Path.Value)]},
-				},
-				Fun: &luau.SelectorExpr{
-					X:   &luau.Ident{Name: "GO"},
-					Sel: &luau.Ident{Name: "import"},
-				},
-			},
-		},
-	}, nil
-}
-
-func ValueSpec(v *ast.ValueSpec, f *ast.File) (luau.Node, error) {
+func ValueSpec(v *ast.ValueSpec, f *File) (luau.Node, error) {
 	names := make([]luau.Node, len(v.Names))
 	for i, v := range v.Names {
 		names[i] = Ident(v, f)
 	}
 
+	var t types.Type
+	if v.Type != nil && len(v.Values) == len(v.Names) {
+		t = f.typeOf(v.Type)
+	}
 	values := make([]luau.Node, len(v.Values))
 	for i, v := range v.Values {
-		e, err := Expr(v, f)
+		e, err := ExprAs(v, t, f)
 		if err != nil {
 			return nil, err
 		}
@@ -219,6 +172,14 @@ func ValueSpec(v *ast.ValueSpec, f *ast.File) (luau.Node, error) {
 		values[i] = e
 	}
 
+	// var x int
+	if len(values) == 0 {
+		values = make([]luau.Node, len(v.Names))
+		for i := range v.Names {
+			values[i] = zeroValue(v.Type, f)
+		}
+	}
+
 	// check if its a transform.Mod
 	if len(v.Names) == 1 && len(v.Values) == 1 {
 		value := v.Values[0]
@@ -232,39 +193,56 @@ func ValueSpec(v *ast.ValueSpec, f *ast.File) (luau.Node, error) {
 		}
 	}
 
-	return &luau.DeclStmt{
-		Scope:  luau.GLOBAL,
+	decl := &luau.DeclStmt{
+		Scope:  luau.LOCAL,
 		Names:  names,
 		Values: values,
-	}, nil
+	}
+
+	if boxes := boxVars(v.Names, f); len(boxes) > 0 {
+		return &luau.Block{List: append([]luau.Node{decl}, boxes...)}, nil
+	}
+	return decl, nil
 }
 
-func TypeSpec(t *ast.TypeSpec, f *ast.File) (*luau.DeclStmt, error) {
+// Type declaration, the table is the metatable of the type's instances
+// ex: local T = {}; T.__index = T
+func TypeSpec(t *ast.TypeSpec, f *File) (*luau.Block, error) {
 	i := Ident(t.Name, f)
-	return &luau.DeclStmt{
-		Scope:  luau.LOCAL,
-		Names:  []luau.Node{i},
-		Values: []luau.Node{&luau.TableLit{Elts: []luau.Node{}}},
-	}, nil
-}
+	block := &luau.Block{
+		List: []luau.Node{
+			&luau.DeclStmt{
+				Scope:  luau.LOCAL,
+				Names:  []luau.Node{i},
+				Values: []luau.Node{&luau.TableLit{Elts: []luau.Node{}}},
+			},
+			&luau.AssignStmt{
+				Left:  []luau.Node{&luau.SelectorExpr{X: i, Sel: &luau.Ident{Name: "__index"}}},
+				Right: []luau.Node{i},
+			},
+		},
+	}
 
-func FuncDecl(f *ast.FuncDecl, file *ast.File) (*luau.FuncStmt, error) {
-	plist := f.Type.Params.List
-	params := []*luau.Ident{}
-	for _, ls := range plist {
-		for _, p := range ls.Names {
-			params = append(params, &luau.Ident{Name: p.Name})
+	if d := Descriptor(t, f); d != nil {
+		block.List = append(block.List, d)
+	}
+	if obj, ok := f.objectOf(t.Name).(*types.TypeName); ok {
+		if n, ok := obj.Type().(*types.Named); ok {
+			block.List = append(block.List, PromotedMethods(n, f)...)
 		}
 	}
+	return block, nil
+}
 
-	c, err := Chunk(f.Body, file)
+func FuncDecl(f *ast.FuncDecl, file *File) (*luau.FuncStmt, error) {
+	params := Params(f.Type, file)
+
+	c, err := FuncBody(f.Recv, f.Type, f.Body, file)
 	if err != nil {
 		return nil, err
 	}
 
-	name := &luau.Ident{
-		Name: f.Name.Name,
-	}
+	name := Ident(f.Name, file)
 
 	if f.Recv != nil {
 		r := f.Recv.List[0]
@@ -275,75 +253,61 @@ func FuncDecl(f *ast.FuncDecl, file *ast.File) (*luau.FuncStmt, error) {
 		i := Ident(r.Names[0], file)
 
 		params = append([]*luau.Ident{i}, params...)
-		rtype, ok := r.Type.(*ast.StarExpr)
-		if !ok {
-			return nil, errors.New("function receivers must be pointers")
+		rtype := r.Type
+		if star, ok := rtype.(*ast.StarExpr); ok {
+			rtype = star.X
 		}
-		recver, ok := rtype.X.(*ast.Ident)
+		recver, ok := rtype.(*ast.Ident)
 		if !ok {
 			return nil, fmt.Errorf("receiver type must be identifier, got %#v", recver)
 		}
 
-		name.Name = recver.Name + "." + name.Name
+		name.Name = Ident(recver, file).Name + "." + name.Name
+	}
+
+	// methods are assigned to the type's table
+	scope := luau.LOCAL
+	if f.Recv != nil {
+		scope = luau.NONE
 	}
 
 	return &luau.FuncStmt{
 		Name:   name,
 		Params: params,
 		Chunk:  c,
-		Scope:  luau.GLOBAL,
+		Scope:  scope,
 	}, nil
 }
 
-func Ident(i *ast.Ident, f *ast.File) *luau.Ident {
-	return &luau.Ident{Name: i.Name}
+// Identifier, mangled unless it's predeclared
+func Ident(i *ast.Ident, f *File) *luau.Ident {
+	if obj := f.objectOf(i); obj != nil && obj.Parent() == types.Universe {
+		return &luau.Ident{Name: i.Name}
+	} else if obj == nil && types.Universe.Lookup(i.Name) != nil {
+		return &luau.Ident{Name: i.Name}
+	}
+	return &luau.Ident{Name: Mangle(i.Name)}
 }
 
 func BasicLit(l *ast.BasicLit) (luau.Node, error) {
 	switch l.Kind {
 	case token.INT, token.FLOAT, token.IMAG:
 		return &luau.NumericLit{Value: l.Value}, nil
-	case token.CHAR, token.STRING:
-		trim := l.Value[1 : len(l.Value)-1]
-		return &luau.StringLit{Value: trim}, nil
-	}
-	return nil, fmt.Errorf("unknown literal: %#v", l)
-}
-
-func CompositeLit(l *ast.CompositeLit, f *ast.File) (luau.Node, error) {
-	switch t := l.Type.(type) {
-	case *ast.ArrayType, *ast.MapType, *ast.Ident:
-		elts := make([]luau.Node, len(l.Elts))
-		for i, v := range l.Elts {
-			e, err := Expr(v, f)
-			if err != nil {
-				return nil, err
-			}
-			elts[i] = e
-		}
-
-		return &luau.TableLit{
-			Elts: elts,
-		}, nil
-	case *ast.ChanType:
-		return nil, errors.New("channels not supported")
-	case *ast.SelectorExpr:
-		x, err := Expr(t.X, f)
+	case token.CHAR:
+		// runes are numbers, like in Go
+		r, _, _, err := strconv.UnquoteChar(l.Value[1:len(l.Value)-1], '\'')
 		if err != nil {
 			return nil, err
 		}
-
-		return &luau.SelectorExpr{
-			X:   x,
-			Sel: Ident(t.Sel, f),
-		}, nil
-	case *ast.StructType:
-
+		return &luau.NumericLit{Value: strconv.Itoa(int(r))}, nil
+	case token.STRING:
+		trim := l.Value[1 : len(l.Value)-1]
+		return &luau.StringLit{Value: trim}, nil
 	}
-	return nil, fmt.Errorf("unknown composite literal: %#v", l)
+	return nil, fmt.Errorf("unknown literal: %#v", l)
 }
 
-func Chunk(b *ast.BlockStmt, f *ast.File) (*luau.Chunk, error) {
+func Chunk(b *ast.BlockStmt, f *File) (*luau.Chunk, error) {
 	ls := b.List
 	result := make([]luau.Node, len(ls))
 	for i, v := range ls {
@@ -362,7 +326,7 @@ func Chunk(b *ast.BlockStmt, f *ast.File) (*luau.Chunk, error) {
 
 var prevExpr ast.Expr
 
-func Expr(e ast.Expr, f *ast.File) (luau.Node, error) {
+func Expr(e ast.Expr, f *File) (luau.Node, error) {
 	if e == nil {
 		fmt.Printf("nil expr: %#v\n", prevExpr)
 		return nil, nil
@@ -376,7 +340,10 @@ func Expr(e ast.Expr, f *ast.File) (luau.Node, error) {
 	case *ast.CallExpr:
 		return CallExpr(expr, f)
 	case *ast.IndexExpr:
-		return IndexExpr(expr, f)
+		return IndexValue(expr, f)
+	case *ast.IndexListExpr:
+		// instantiated generic functions are the functions themselves
+		return Expr(expr.X, f)
 	case *ast.ParenExpr:
 		return ParenExpr(expr, f)
 	case *ast.SelectorExpr:
@@ -384,7 +351,7 @@ func Expr(e ast.Expr, f *ast.File) (luau.Node, error) {
 	case *ast.BasicLit:
 		return BasicLit(expr)
 	case *ast.Ident:
-		return Ident(expr, f), nil
+		return IdentExpr(expr, f), nil
 	case *ast.KeyValueExpr:
 		return KeyValueExpr(expr, f)
 	case *ast.CompositeLit:
@@ -393,8 +360,10 @@ func Expr(e ast.Expr, f *ast.File) (luau.Node, error) {
 		return UnaryExpr(expr, f)
 	case *ast.SliceExpr:
 		return SliceExpr(expr, f)
+	case *ast.FuncLit:
+		return FuncLit(expr, f)
 	case *ast.StarExpr:
-		return nil, nil
+		return StarExpr(expr, f)
 	}
 
 	prevExpr = e
@@ -402,73 +371,65 @@ func Expr(e ast.Expr, f *ast.File) (luau.Node, error) {
 	return nil, fmt.Errorf("unknown expression: %#v", e)
 }
 
-func SliceExpr(s *ast.SliceExpr, f *ast.File) (luau.Node, error) {
-	if s.Slice3 {
-		return nil, fmt.Errorf("3-index slices are not supported")
-	}
+// ex: s[1:n] -> GO.slice(s, 1, n, nil)
+func SliceExpr(s *ast.SliceExpr, f *File) (luau.Node, error) {
+	args := []luau.Node{}
+	for _, e := range []ast.Expr{s.X, s.Low, s.High, s.Max} {
+		if e == nil {
+			args = append(args, &luau.Ident{Name: "nil"})
+			continue
+		}
 
-	low, err := Expr(s.Low, f)
-	if err != nil {
-		return nil, err
+		n, err := Expr(e, f)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, n)
 	}
 
-	max, err := Expr(s.Max, f)
-	if err != nil {
-		return nil, err
-	}
+	return runtimeCall("slice", args...), nil
+}
 
-	x, err := Expr(s.X, f)
-	if err != nil {
-		return nil, err
+func UnaryExpr(u *ast.UnaryExpr, f *File) (luau.Node, error) {
+	if u.Op == token.AND {
+		return AddrOf(u.X, f)
+	}
+	if u.Op == token.ARROW {
+		c, err := recvCall(u, f)
+		if err != nil {
+			return nil, err
+		}
+		return &luau.ParenExpr{X: c}, nil
 	}
 
-	return &luau.CallExpr{
-		Fun: &luau.SelectorExpr{
-			X: &luau.Ident{
-				Name: "GO",
-			},
-			Sel: &luau.Ident{
-				Name: "slice",
-			},
-		},
-		Args: []luau.Node{x, low, max},
-	}, nil
-}
-
-func UnaryExpr(u *ast.UnaryExpr, f *ast.File) (luau.Node, error) {
 	x, err := Expr(u.X, f)
 	if err != nil {
 		return nil, err
 	}
 
-	// if constructing a struct
-	if c, ok := u.X.(*ast.CompositeLit); ok && u.Op == token.AND {
-		elts := make([]luau.Node, len(c.Elts))
-		for i, v := range c.Elts {
-			res, err := Expr(v, f)
-			if err != nil {
-				return nil, err
-			}
-			elts[i] = res
-		}
-
-		return &luau.CallExpr{
-			Fun: &luau.Ident{
-				Name: "setmetatable",
-			},
-			Args: []luau.Node{
-				&luau.TableLit{
-					Elts: elts,
-				},
-				x,
-			},
+	switch u.Op {
+	case token.SUB, token.NOT:
+		return &luau.UnaryExpr{
+			Op: Token(u.Op),
+			X:  x,
 		}, nil
 	}
 
 	return x, nil
 }
 
-func BinaryExpr(e *ast.BinaryExpr, f *ast.File) (*luau.BinaryExpr, error) {
+func BinaryExpr(e *ast.BinaryExpr, f *File) (luau.Node, error) {
+	// constant expressions are folded, ex: 100 * time.Millisecond
+	if tv, ok := f.Pkg.Info.Types[e]; ok && tv.Value != nil {
+		if n, ok := constLit(tv.Value); ok {
+			return n, nil
+		}
+	}
+
+	if n, ok, err := Equality(e, f); ok || err != nil {
+		return n, err
+	}
+
 	op := Token(e.Op)
 	left, err := Expr(e.X, f)
 	if err != nil {
@@ -480,6 +441,10 @@ func BinaryExpr(e *ast.BinaryExpr, f *ast.File) (*luau.BinaryExpr, error) {
 		return nil, err
 	}
 
+	if op == luau.ADD && isString(f.typeOf(e.X)) {
+		op = luau.CCT
+	}
+
 	{
 		if _, srok := right.(*luau.StringLit); srok {
 			if op == luau.ADD_ASSIGN {
@@ -499,7 +464,7 @@ func BinaryExpr(e *ast.BinaryExpr, f *ast.File) (*luau.BinaryExpr, error) {
 	}, nil
 }
 
-func KeyValueExpr(k *ast.KeyValueExpr, f *ast.File) (*luau.KeyValueExpr, error) {
+func KeyValueExpr(k *ast.KeyValueExpr, f *File) (*luau.KeyValueExpr, error) {
 	key, err := Expr(k.Key, f)
 	if err != nil {
 		return nil, err
@@ -515,8 +480,20 @@ func KeyValueExpr(k *ast.KeyValueExpr, f *ast.File) (*luau.KeyValueExpr, error)
 	}, nil
 }
 
-func CallExpr(c *ast.CallExpr, f *ast.File) (luau.Node, error) {
-	fn, err := Expr(c.Fun, f)
+func CallExpr(c *ast.CallExpr, f *File) (luau.Node, error) {
+	if tv, ok := f.Pkg.Info.Types[c.Fun]; ok && tv.IsType() {
+		return Conversion(c, f)
+	}
+
+	if id, ok := ast.Unparen(c.Fun).(*ast.Ident); ok {
+		if _, ok := f.objectOf(id).(*types.Builtin); ok {
+			if node, ok, err := BuiltinCallExpr(id.Name, c, f); ok || err != nil {
+				return node, err
+			}
+		}
+	}
+
+	fn, err := prefix(c.Fun, f)
 	if err != nil {
 		return nil, err
 	}
@@ -540,34 +517,74 @@ func CallExpr(c *ast.CallExpr, f *ast.File) (luau.Node, error) {
 		}
 
 		// if method has an object, add self arg
-		if id, ok := sl.X.(*ast.Ident); ok && id.Obj != nil {
-			args = append(args, Ident(id, f))
+		if sel, ok := f.Pkg.Info.Selections[sl]; ok {
+			if sel.Kind() != types.MethodVal {
+				return nil, nil
+			}
+
+			var self luau.Node
+			var err error
+			switch {
+			case len(sel.Index()) > 1:
+				self, err = Expr(sl.X, f)
+				if err == nil {
+					self = promotedRecv(self, sel)
+				}
+			case implicitAddr(f.Pkg.Info, sl):
+				self, err = AddrOf(sl.X, f)
+			case implicitDeref(f.Pkg.Info, sl):
+				self, err = StarExpr(&ast.StarExpr{X: sl.X}, f)
+			default:
+				self, err = Expr(sl.X, f)
+			}
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, self)
+
+			if m := methodFunc(sel, f); m != nil {
+				fn = m
+			}
+		} else if id, ok := sl.X.(*ast.Ident); ok && id.Obj != nil {
+			args = append(args, IdentExpr(id, f))
 		}
 		return nil, nil
 	}
 
 	// check if its a struct method
 	if sl, ok := c.Fun.(*ast.SelectorExpr); ok {
-		if node, err := method(sl); node != nil {
+		if node, err := method(sl); node != nil || err != nil {
 			return node, err
 		}
 	}
 
 	if il, ok := c.Fun.(*ast.IndexExpr); ok {
 		if sl, ok := il.X.(*ast.SelectorExpr); ok {
-			if node, err := method(sl); node != nil {
+			if node, err := method(sl); node != nil || err != nil {
 				return node, err
 			}
 		}
 	}
 
-	for _, v := range c.Args {
-		e, err := Expr(v, f)
-		if err != nil {
-			return nil, err
-		}
-		args = append(args, e)
+	var params *types.Tuple
+	variadic := false
+	if sig, ok := underlying(f.typeOf(c.Fun)).(*types.Signature); ok {
+		params = sig.Params()
+		// f(s...) passes the slice itself
+		variadic = sig.Variadic() && !c.Ellipsis.IsValid()
+	}
+	values, err := exprsAs(c.Args, params, variadic, f)
+	if err != nil {
+		return nil, err
+	}
+	fmtArgs(c, values, f)
+	args = append(args, values...)
+
+	// f(s...) passes the elements of s as varargs
+	if c.Ellipsis.IsValid() && len(args) > 0 {
+		args[len(args)-1] = runtimeCall("unpack", args[len(args)-1])
 	}
+	args = append(args, stdCallArgs(c, f)...)
 
 	call := &luau.CallExpr{
 		Fun:  fn,
@@ -579,13 +596,130 @@ func CallExpr(c *ast.CallExpr, f *ast.File) (luau.Node, error) {
 	}
 	return call, nil
 }
-func IndexExpr(i *ast.IndexExpr, f *ast.File) (*luau.IndexExpr, error) {
-	x, err := Expr(i.X, f)
+
+// methods of concrete types are called statically on the type declaring them,
+// values of non-struct types can't look them up
+// ex: Counter.Inc(c), sync.Mutex.Lock(c.Mutex)
+func methodFunc(sel *types.Selection, f *File) luau.Node {
+	recv := sel.Obj().Type().(*types.Signature).Recv().Type()
+	if p, ok := recv.(*types.Pointer); ok {
+		recv = p.Elem()
+	}
+
+	named, ok := recv.(*types.Named)
+	if !ok || types.IsInterface(named) {
+		return nil
+	}
+
+	return &luau.SelectorExpr{
+		X:   typeName(named, f),
+		Sel: &luau.Ident{Name: Mangle(sel.Obj().Name())},
+	}
+}
+
+// Index read as a value, nil maps read through variables and fields are guarded
+// ex: s[i] -> s[i], str[i] -> string.byte(str, i + 1), m[k] -> (m and m[k] or 0)
+func IndexValue(i *ast.IndexExpr, f *File) (luau.Node, error) {
+	// instantiated generic function
+	if tv, ok := f.Pkg.Info.Types[i.Index]; ok && tv.IsType() {
+		return Expr(i.X, f)
+	}
+
+	t := f.typeOf(i.X)
+	if isString(t) {
+		x, err := Expr(i.X, f)
+		if err != nil {
+			return nil, err
+		}
+		index, err := Expr(i.Index, f)
+		if err != nil {
+			return nil, err
+		}
+
+		return &luau.CallExpr{
+			Fun: &luau.SelectorExpr{
+				X:   &luau.Ident{Name: "string"},
+				Sel: &luau.Ident{Name: "byte"},
+			},
+			Args: []luau.Node{x, &luau.BinaryExpr{
+				Left:  index,
+				Right: &luau.NumericLit{Value: "1"},
+				Op:    luau.ADD,
+			}},
+		}, nil
+	}
+
+	index, err := IndexExpr(i, f)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := underlying(t).(*types.Map)
+	if !ok {
+		return index, nil
+	}
+
+	var v luau.Node = index
+	if isVar(i.X) {
+		v = &luau.BinaryExpr{Left: index.X, Right: index, Op: luau.AND}
+	}
+	// missing keys read as the zero value
+	if z := zero(m.Elem(), f); !isNil(z) {
+		v = &luau.BinaryExpr{Left: v, Right: z, Op: luau.OR}
+	}
+	if v == luau.Node(index) {
+		return index, nil
+	}
+	return &luau.ParenExpr{X: v}, nil
+}
+
+// variables and their fields, which can be read twice
+func isVar(e ast.Expr) bool {
+	switch e := ast.Unparen(e).(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		return isVar(e.X)
+	}
+	return false
+}
+
+func isMapIndex(i *ast.IndexExpr, f *File) bool {
+	_, ok := underlying(f.typeOf(i.X)).(*types.Map)
+	return ok
+}
+
+// m[k] op= y -> m[k] = (m[k] or zero) op y
+func updateIndex(i *ast.IndexExpr, op token.Token, y ast.Expr, f *File) (luau.Node, error) {
+	left, err := IndexExpr(i, f)
 	if err != nil {
 		return nil, err
 	}
 
-	index, err := Expr(i.Index, f)
+	right, err := BinaryExpr(&ast.BinaryExpr{X: i, Op: op, Y: y}, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &luau.AssignStmt{
+		Left:  []luau.Node{left},
+		Right: []luau.Node{right},
+	}, nil
+}
+
+// Index as a location, assigned to or referenced
+func IndexExpr(i *ast.IndexExpr, f *File) (*luau.IndexExpr, error) {
+	x, err := prefix(i.X, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var index luau.Node
+	if m, ok := underlying(f.typeOf(i.X)).(*types.Map); ok {
+		index, err = MapKey(i.Index, m.Key(), f)
+	} else {
+		index, err = Expr(i.Index, f)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -595,7 +729,7 @@ func IndexExpr(i *ast.IndexExpr, f *ast.File) (*luau.IndexExpr, error) {
 		X:     x,
 	}, nil
 }
-func ParenExpr(p *ast.ParenExpr, f *ast.File) (*luau.ParenExpr, error) {
+func ParenExpr(p *ast.ParenExpr, f *File) (*luau.ParenExpr, error) {
 	x, err := Expr(p.X, f)
 	if err != nil {
 		return nil, err
@@ -605,12 +739,25 @@ func ParenExpr(p *ast.ParenExpr, f *ast.File) (*luau.ParenExpr, error) {
 	}, nil
 }
 
-func SelectorExpr(s *ast.SelectorExpr, f *ast.File) (*luau.SelectorExpr, error) {
+func SelectorExpr(s *ast.SelectorExpr, f *File) (luau.Node, error) {
+	if f.isPkgSelector(s) {
+		if n, ok := stdMember(f.Pkg.Info.Uses[s.Sel]); ok {
+			return n, nil
+		}
+		return &luau.SelectorExpr{
+			X:   f.pkgLocal(f.selectedPkg(s)),
+			Sel: &luau.Ident{Name: s.Sel.Name},
+		}, nil
+	}
+
 	sel := Ident(s.Sel, f)
-	x, err := Expr(s.X, f)
+	x, err := prefix(s.X, f)
 	if err != nil {
 		return nil, err
 	}
+	if ps, ok := f.Pkg.Info.Selections[s]; ok && len(ps.Index()) > 1 {
+		x, _ = promoted(x, ps)
+	}
 
 	return &luau.SelectorExpr{
 		Sel: sel,
@@ -620,9 +767,9 @@ func SelectorExpr(s *ast.SelectorExpr, f *ast.File) (*luau.SelectorExpr, error)
 
 var prevStmt ast.Stmt
 
-func Stmt(s ast.Stmt, f *ast.File) (luau.Node, error) {
+func Stmt(s ast.Stmt, f *File) (luau.Node, error) {
 	if s == nil {
-		fmt.Println("nil statement: %#v\n", prevStmt)
+		fmt.Printf("nil statement: %#v\n", prevStmt)
 		return nil, nil
 	}
 
@@ -641,12 +788,24 @@ func Stmt(s ast.Stmt, f *ast.File) (luau.Node, error) {
 		return RangeStmt(stmt, f)
 	case *ast.ForStmt:
 		return ForStmt(stmt, f)
+	case *ast.DeferStmt:
+		return DeferStmt(stmt, f)
+	case *ast.IncDecStmt:
+		return IncDecStmt(stmt, f)
+	case *ast.DeclStmt:
+		return Decl(stmt.Decl, f)
+	case *ast.GoStmt:
+		return GoStmt(stmt, f)
+	case *ast.SendStmt:
+		return SendStmt(stmt, f)
+	case *ast.SelectStmt:
+		return SelectStmt(stmt, f)
 	}
 	prevStmt = s
 	return nil, fmt.Errorf("unknown statement: %#v", s)
 }
 
-func ForStmt(s *ast.ForStmt, f *ast.File) (luau.Node, error) {
+func ForStmt(s *ast.ForStmt, f *File) (luau.Node, error) {
 	body, err := Chunk(s.Body, f)
 	if err != nil {
 		return nil, err
@@ -654,7 +813,7 @@ func ForStmt(s *ast.ForStmt, f *ast.File) (luau.Node, error) {
 
 	if s.Init == nil && s.Cond == nil && s.Post == nil {
 		return &luau.WhileStmt{
-			Exp:   &luau.Raw{Content: "true"},
+			Exp:   &luau.Ident{Name: "true"},
 			Chunk: body,
 		}, nil
 	}
@@ -665,7 +824,7 @@ func ForStmt(s *ast.ForStmt, f *ast.File) (luau.Node, error) {
 	}, nil
 }
 
-func IfStmt(i *ast.IfStmt, f *ast.File) (*luau.IfStmt, error) {
+func IfStmt(i *ast.IfStmt, f *File) (luau.Node, error) {
 	cond, err := Expr(i.Cond, f)
 	if err != nil {
 		return nil, err
@@ -676,22 +835,61 @@ func IfStmt(i *ast.IfStmt, f *ast.File) (*luau.IfStmt, error) {
 		return nil, err
 	}
 
-	els, err := Stmt(i.Else, f)
-	if err != nil {
-		return nil, err
+	var els luau.Node
+	if i.Else != nil {
+		els, err = Stmt(i.Else, f)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &luau.IfStmt{
+	stmt := &luau.IfStmt{
 		Cond: cond,
 		Body: chunk,
 		Else: els,
+	}
+
+	if i.Init == nil {
+		return stmt, nil
+	}
+
+	// scope the init statement to the if statement
+	init, err := Stmt(i.Init, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &luau.DoStmt{
+		Chunk: &luau.Chunk{
+			List: []luau.Node{init, stmt},
+		},
 	}, nil
 }
 
-func AssignStmt(a *ast.AssignStmt, f *ast.File) (luau.Node, error) {
+func AssignStmt(a *ast.AssignStmt, f *File) (luau.Node, error) {
+	// map elements can't be updated in place, they are read with their zero value
+	// ex: m[k] += 1 -> m[k] = (m[k] or 0) + 1
+	if ix, ok := ast.Unparen(a.Lhs[0]).(*ast.IndexExpr); ok && isMapIndex(ix, f) &&
+		a.Tok != token.ASSIGN && a.Tok != token.DEFINE {
+		return updateIndex(ix, a.Tok-(token.ADD_ASSIGN-token.ADD), a.Rhs[0], f)
+	}
+
 	left := make([]luau.Node, len(a.Lhs))
+	// names := redeclares are assigned, through their boxes if they have one
+	declared := []luau.Node{}
+	redeclares := false
 	for i, v := range a.Lhs {
-		e, err := Expr(v, f)
+		// declared names are never read through a box
+		if id, ok := v.(*ast.Ident); ok && a.Tok == token.DEFINE {
+			if id.Name == "_" || f.Pkg.Info.Defs[id] != nil {
+				left[i] = Ident(id, f)
+				declared = append(declared, left[i])
+				continue
+			}
+			redeclares = true
+		}
+
+		e, err := lvalue(v, f)
 		if err != nil {
 			return nil, err
 		}
@@ -701,7 +899,11 @@ func AssignStmt(a *ast.AssignStmt, f *ast.File) (luau.Node, error) {
 
 	right := make([]luau.Node, len(a.Rhs))
 	for i, v := range a.Rhs {
-		e, err := Expr(v, f)
+		var t types.Type
+		if (a.Tok == token.ASSIGN || a.Tok == token.DEFINE) && len(a.Lhs) == len(a.Rhs) {
+			t = f.typeOf(a.Lhs[i])
+		}
+		e, err := ExprAs(v, t, f)
 		if err != nil {
 			return nil, err
 		}
@@ -709,21 +911,128 @@ func AssignStmt(a *ast.AssignStmt, f *ast.File) (luau.Node, error) {
 		right[i] = e
 	}
 
-	if a.Tok == token.DEFINE {
-		return &luau.DeclStmt{
+	// v, ok := m[k]
+	if ix, ok := ast.Unparen(a.Rhs[0]).(*ast.IndexExpr); ok && len(a.Lhs) == 2 && isMapIndex(ix, f) {
+		m, err := IndexExpr(ix, f)
+		if err != nil {
+			return nil, err
+		}
+		elem := underlying(f.typeOf(ix.X)).(*types.Map).Elem()
+		right[0] = runtimeCall("lookup", m.X, m.Index, zero(elem, f))
+	}
+
+	// v, ok := <-c
+	if u, ok := isRecv(a.Rhs[0]); ok && len(a.Lhs) == 2 {
+		c, err := recvCall(u, f)
+		if err != nil {
+			return nil, err
+		}
+		right[0] = c
+	}
+
+	switch a.Tok {
+	case token.DEFINE:
+		names := []*ast.Ident{}
+		for _, v := range a.Lhs {
+			if id, ok := v.(*ast.Ident); ok && f.Pkg.Info.Defs[id] != nil {
+				names = append(names, id)
+			}
+		}
+		boxes := boxVars(names, f)
+
+		// ex: x, w := 2, 3 -> local w; x.v,w = 2,3
+		if redeclares {
+			list := []luau.Node{}
+			if len(declared) > 0 {
+				list = append(list, &luau.DeclStmt{Scope: luau.LOCAL, Names: declared})
+			}
+			list = append(list, &luau.AssignStmt{Left: left, Right: right})
+			return &luau.Block{List: append(list, boxes...)}, nil
+		}
+
+		decl := &luau.DeclStmt{
 			Scope:  luau.LOCAL,
 			Names:  left,
 			Values: right,
+		}
+		if len(boxes) > 0 {
+			return &luau.Block{List: append([]luau.Node{decl}, boxes...)}, nil
+		}
+		return decl, nil
+	case token.ASSIGN:
+		return &luau.AssignStmt{
+			Left:  left,
+			Right: right,
 		}, nil
 	}
 
-	return &luau.AssignStmt{
-		Left:  left,
-		Right: right,
+	op := Token(a.Tok)
+	if op == luau.ADD_ASSIGN && isString(f.typeOf(a.Lhs[0])) {
+		op = luau.CCT_ASSIGN
+	}
+	if op == luau.ILLEGAL {
+		return nil, fmt.Errorf("unsupported assignment: %s", a.Tok)
+	}
+
+	return &luau.CompoundAssignStmt{
+		Left:  left[0],
+		Right: right[0],
+		Op:    op,
+	}, nil
+}
+
+// assigned expression, elements are assigned in place rather than read
+// prefix transforms the operand of an index, a selector or a call.
+// Parentheses around it are dropped when it's a prefix expression in Luau already,
+// since a statement starting with one is ambiguous with a call on the previous line
+// ex: (*ps)[0] = 5 -> ps.v[0] = 5
+func prefix(e ast.Expr, f *File) (luau.Node, error) {
+	n, err := Expr(ast.Unparen(e), f)
+	if err != nil {
+		return nil, err
+	}
+	switch n.(type) {
+	case *luau.Ident, *luau.SelectorExpr, *luau.IndexExpr, *luau.CallExpr, *luau.ParenExpr:
+		return n, nil
+	}
+	return &luau.ParenExpr{X: n}, nil
+}
+
+func lvalue(e ast.Expr, f *File) (luau.Node, error) {
+	if ix, ok := ast.Unparen(e).(*ast.IndexExpr); ok {
+		return IndexExpr(ix, f)
+	}
+	return Expr(e, f)
+}
+
+// ex: x++
+func IncDecStmt(s *ast.IncDecStmt, f *File) (luau.Node, error) {
+	if ix, ok := ast.Unparen(s.X).(*ast.IndexExpr); ok && isMapIndex(ix, f) {
+		op := token.ADD
+		if s.Tok == token.DEC {
+			op = token.SUB
+		}
+		return updateIndex(ix, op, &ast.BasicLit{Kind: token.INT, Value: "1"}, f)
+	}
+
+	x, err := Expr(s.X, f)
+	if err != nil {
+		return nil, err
+	}
+
+	op := luau.ADD_ASSIGN
+	if s.Tok == token.DEC {
+		op = luau.SUB_ASSIGN
+	}
+
+	return &luau.CompoundAssignStmt{
+		Left:  x,
+		Right: &luau.NumericLit{Value: "1"},
+		Op:    op,
 	}, nil
 }
 
-func BlockStmt(b *ast.BlockStmt, f *ast.File) (*luau.DoStmt, error) {
+func BlockStmt(b *ast.BlockStmt, f *File) (*luau.DoStmt, error) {
 	c, err := Chunk(b, f)
 	if err != nil {
 		return nil, err
@@ -733,7 +1042,7 @@ func BlockStmt(b *ast.BlockStmt, f *ast.File) (*luau.DoStmt, error) {
 	}, nil
 }
 
-func ExprStmt(e *ast.ExprStmt, f *ast.File) (*luau.ExprStmt, error) {
+func ExprStmt(e *ast.ExprStmt, f *File) (*luau.ExprStmt, error) {
 	expr, err := Expr(e.X, f)
 	if err != nil {
 		return nil, err
@@ -743,51 +1052,184 @@ func ExprStmt(e *ast.ExprStmt, f *ast.File) (*luau.ExprStmt, error) {
 	}, nil
 }
 
-func ReturnStmt(r *ast.ReturnStmt, f *ast.File) (*luau.ReturnStmt, error) {
-	res := make([]luau.Node, len(r.Results))
-	for i, v := range r.Results {
-		e, err := Expr(v, f)
-		if err != nil {
-			return nil, err
+func ReturnStmt(r *ast.ReturnStmt, f *File) (luau.Node, error) {
+	s := f.fn()
+	var results *types.Tuple
+	if s != nil && s.types != nil && s.types.Len() == len(r.Results) {
+		results = s.types
+	}
+	res, err := exprsAs(r.Results, results, false, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil || len(s.results) == 0 {
+		return stopIter(&luau.ReturnStmt{
+			Results: res,
+		}, f), nil
+	}
+
+	// bare return
+	if len(res) == 0 {
+		if s.defers {
+			return stopIter(&luau.ReturnStmt{}, f), nil
 		}
+		return stopIter(&luau.ReturnStmt{Results: s.results}, f), nil
+	}
 
-		res[i] = e
+	// results are set before the deferred calls run
+	if s.defers {
+		return &luau.Block{
+			List: []luau.Node{
+				&luau.AssignStmt{Left: s.results, Right: res},
+				stopIter(&luau.ReturnStmt{}, f),
+			},
+		}, nil
 	}
 
-	return &luau.ReturnStmt{
+	return stopIter(&luau.ReturnStmt{
 		Results: res,
-	}, nil
+	}, f), nil
 }
 
-func RangeStmt(r *ast.RangeStmt, f *ast.File) (*luau.GenericForStmt, error) {
-	k, err := Expr(r.Key, f)
+// returns inside of range over function bodies stop the iteration,
+// a leading true tells them apart from the body running to its end
+// ex: return x -> return true, x
+func stopIter(r *luau.ReturnStmt, f *File) *luau.ReturnStmt {
+	if s := f.fn(); s != nil && s.yields > 0 {
+		r.Results = append([]luau.Node{&luau.Ident{Name: "true"}}, r.Results...)
+	}
+	return r
+}
+
+// RangeFunc transforms a range over a function iterator, the loop body is the yield function.
+// Returning from the body stops the iteration, and the enclosing function
+// then returns what the body did
+// ex: for k, v := range seq -> GO.iter(seq, function(k, v) ... end)
+func RangeFunc(r *ast.RangeStmt, f *File) (luau.Node, error) {
+	params := []*luau.Ident{rangeVar(r.Key, f)}
+	if r.Value != nil {
+		params = append(params, rangeVar(r.Value, f))
+	}
+
+	s := f.fn()
+	s.yields++
+	body, err := Chunk(r.Body, f)
+	s.yields--
 	if err != nil {
 		return nil, err
 	}
-	if k == nil {
-		k = &luau.Ident{
-			Name: "_",
+
+	if r.Tok == token.DEFINE {
+		names := []*ast.Ident{}
+		for _, e := range []ast.Expr{r.Key, r.Value} {
+			if id, ok := e.(*ast.Ident); ok {
+				names = append(names, id)
+			}
 		}
+		body.List = append(boxVars(names, f), body.List...)
 	}
 
-	v, err := Expr(r.Value, f)
+	seq, err := Expr(r.X, f)
 	if err != nil {
 		return nil, err
 	}
 
+	call := runtimeCall("iter", seq, &luau.FuncLit{Params: params, Chunk: body})
+	if !hasReturn(r.Body) {
+		return &luau.ExprStmt{X: call}, nil
+	}
+
+	// ex: local __ret = GO.iter(...) if __ret then return table.unpack(__ret, 2, __ret.n) end
+	ret := &luau.Ident{Name: "__ret"}
+	unpack := &luau.CallExpr{
+		Fun: &luau.SelectorExpr{X: &luau.Ident{Name: "table"}, Sel: &luau.Ident{Name: "unpack"}},
+		Args: []luau.Node{
+			ret,
+			&luau.NumericLit{Value: "2"},
+			&luau.SelectorExpr{X: ret, Sel: &luau.Ident{Name: "n"}},
+		},
+	}
+	return &luau.DoStmt{Chunk: &luau.Chunk{List: []luau.Node{
+		&luau.DeclStmt{
+			Scope:  luau.LOCAL,
+			Names:  []luau.Node{ret},
+			Values: []luau.Node{call},
+		},
+		&luau.IfStmt{
+			Cond: ret,
+			Body: &luau.Chunk{List: []luau.Node{stopIter(&luau.ReturnStmt{Results: []luau.Node{unpack}}, f)}},
+		},
+	}}}, nil
+}
+
+func rangeVar(e ast.Expr, f *File) *luau.Ident {
+	if id, ok := e.(*ast.Ident); ok {
+		return Ident(id, f)
+	}
+	return &luau.Ident{
+		Name: "_",
+	}
+}
+
+// Range statement, integers count up and strings yield runes
+// ex: for i, v := range s -> for i, v in s, for i := range n -> for i = 0, n - 1, 1
+func RangeStmt(r *ast.RangeStmt, f *File) (luau.Node, error) {
+	if _, ok := underlying(f.typeOf(r.X)).(*types.Signature); ok && f.fn() != nil {
+		return RangeFunc(r, f)
+	}
+
+	idents := []*luau.Ident{rangeVar(r.Key, f)}
+	if r.Value != nil {
+		idents = append(idents, rangeVar(r.Value, f))
+	}
+
 	body, err := Chunk(r.Body, f)
 	if err != nil {
 		return nil, err
 	}
 
+	if r.Tok == token.DEFINE {
+		names := []*ast.Ident{}
+		for _, e := range []ast.Expr{r.Key, r.Value} {
+			if id, ok := e.(*ast.Ident); ok {
+				names = append(names, id)
+			}
+		}
+		body.List = append(boxVars(names, f), body.List...)
+	}
+
 	iter, err := Expr(r.X, f)
 	if err != nil {
 		return nil, err
 	}
 
+	switch u := underlying(f.typeOf(r.X)).(type) {
+	case *types.Basic:
+		if u.Info()&types.IsString != 0 {
+			iter = runtimeCall("runes", iter)
+			break
+		}
+
+		return &luau.NumericForStmt{
+			Chunk: body,
+			Init:  &luau.KeyValueExpr{Key: idents[0], Value: &luau.NumericLit{Value: "0"}},
+			Cond:  &luau.BinaryExpr{Left: iter, Right: &luau.NumericLit{Value: "1"}, Op: luau.SUB},
+			End:   &luau.NumericLit{Value: "1"},
+		}, nil
+	case *types.Chan:
+		iter = runtimeCall("chaniter", iter)
+		idents = append([]*luau.Ident{{Name: "_"}}, idents...)
+	case *types.Slice, *types.Map:
+		// ranging over nil does nothing
+		if !notNil(iter) {
+			iter = &luau.BinaryExpr{Left: iter, Right: &luau.TableLit{}, Op: luau.OR}
+		}
+	}
+
 	return &luau.GenericForStmt{
 		Chunk:  body,
-		Idents: []*luau.Ident{k.(*luau.Ident), v.(*luau.Ident)},
+		Idents: idents,
 		Iter:   iter,
 	}, nil
 }
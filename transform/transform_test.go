@@ -2,6 +2,9 @@ package transform
 
 import (
 	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
 	"testing"
 
 	"github.com/intervinn/abq/luau"
@@ -142,3 +145,1433 @@ func TestMod(t *testing.T) {
 		fmt.Println(w.Content)
 	}
 }
+
+// render every declaration of the source into one string
+func render(t *testing.T, name string, text string) string {
+	t.Helper()
+	src, err := Source(name, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := luau.NewStringWriter()
+	for _, s := range src {
+		s.Render(w)
+	}
+	return w.Content
+}
+
+func TestNamedResults(t *testing.T) {
+	text := `
+	package main
+
+	func parse() (n int, err error) {
+		n = 5
+		return
+	}
+
+	func safe() (n int, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				n = -1
+			}
+		}()
+		panic("boom")
+	}
+
+	func unnamed() int {
+		defer fmt.Println("done")
+		return 2
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local n,err = 0,nil",
+		"return n,err",
+		"GO.rundefers(__defers,__ok,__err)",
+		"local __r0 = 0",
+		"__r0 = 2",
+		"GO.defer(__defers,fmt.Println,\"done\")",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestPointers(t *testing.T) {
+	text := `
+	package main
+
+	type Point struct {
+		X, Y int
+	}
+
+	type Counter int
+
+	func (c *Counter) Inc() {
+		*c++
+	}
+
+	func set(p *int, v int) {
+		*p = v
+	}
+
+	func main() {
+		x := 1
+		set(&x, 2)
+
+		var c Counter
+		c.Inc()
+
+		pt := &Point{X: 1}
+		set(&pt.Y, 3)
+
+		a := []int{1, 2, 3}
+		set(&a[0], 4)
+
+		n := new(int)
+		*n = x
+
+		y := 1
+		q := &y
+		y, w := 2, 3
+
+		s := []int{1}
+		ps := &s
+		(*ps)[0] = 5
+		m := map[string]int{}
+		pm := &m
+		(*pm)["a"] = 1
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"c.v += 1",
+		"p.v = v",
+		"x = {\n\t\tv = x\n\t}",
+		"set(x,2)",
+		"Counter.Inc(c)",
+		"set(GO.ref(pt,\"Y\"),3)",
+		"set(GO.ref(a,0),4)",
+		"n.v = x.v",
+		"local w\n\ty.v,w = 2,3",
+		"ps.v[0] = 5",
+		"pm.v[\"a\"] = 1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestStructLits(t *testing.T) {
+	text := `
+	package main
+
+	import "github.com/intervinn/abq/luau"
+
+	type Point struct {
+		X, Y int
+	}
+
+	type Line struct {
+		From, To Point
+		Name     string
+	}
+
+	func main() {
+		a := Point{1, 2}
+		b := Point{Y: 3}
+		l := &Line{To: a}
+		ps := []*Point{{1, 2}, {X: 5}}
+		m := map[string]Point{"origin": {}}
+		anon := struct{ On bool }{true}
+		id := luau.Ident{Name: "a"}
+		ret := luau.ReturnStmt{nil}
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"Point.__index = Point",
+		"local a = setmetatable({\n\t\tX = 1,\n\t\tY = 2\n\t},Point)",
+		"X = 0,\n\t\tY = 3",
+		"From = setmetatable({",
+		"To = a,\n\t\tName = \"\"\n\t},Line)",
+		"[\"origin\"] = setmetatable({",
+		"local anon = {\n\t\tOn = true\n\t}",
+		"local id = setmetatable({\n\t\tName = \"a\"\n\t},luau.Ident)",
+		"local ret = setmetatable({\n\t\tResults = nil\n\t},luau.ReturnStmt)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+
+	// literals of types which can't be resolved would lose their methods
+	t.Setenv("GOPROXY", "off")
+	_, err := Source("main.go", `
+	package main
+
+	import "github.com/foo/bar"
+
+	var v = bar.Value{1, 2}
+	`)
+	if err == nil || !strings.Contains(err.Error(), "bar.Value") {
+		t.Errorf("expected an error for the unresolved type, got %v", err)
+	}
+}
+
+func TestMangle(t *testing.T) {
+	text := `
+	package main
+
+	type table struct {
+		end int
+	}
+
+	func (t *table) local() int {
+		return t.end
+	}
+
+	var GO = 1
+	var string_ = "a"
+
+	func main() {
+		then := &table{end: 2}
+		repeat := then.local()
+		var x interface{} = nil
+		if x == nil && true {
+			repeat = GO
+		}
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local table_ = {}",
+		"function table_.local_(t)",
+		"return t.end_",
+		"GO_ = 1",
+		"string__ = \"a\"",
+		"local then_ = setmetatable({\n\t\tend_ = 2\n\t},table_)",
+		"local repeat_ = table_.local_(then_)",
+		"local x = nil",
+		"x == nil and true",
+		"repeat_ = GO_",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+
+	if !strings.Contains(out, "if key == \"GO\" then\n\t\t\treturn GO_") {
+		t.Error("export key not preserved")
+	}
+}
+
+func TestModuleLocals(t *testing.T) {
+	text := `
+	package parity
+
+	var Config = 1
+
+	type Counter struct{}
+
+	func (c *Counter) Even(n int) bool {
+		return even(n)
+	}
+
+	func even(n int) bool {
+		if n == 0 {
+			return true
+		}
+		return odd(n - 1)
+	}
+
+	func odd(n int) bool {
+		if n == 0 {
+			return false
+		}
+		return even(n - 1)
+	}
+	`
+
+	src, err := Source("parity.go", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := luau.NewFile("parity", "")
+	file.Decls = append(file.Decls, src...)
+
+	w := luau.NewStringWriter()
+	file.Render(w)
+	fmt.Println(w.Content)
+
+	for _, want := range []string{
+		"local even\n",
+		"local Config = 1",
+		"local Counter = {}",
+		"local function odd(n)",
+		"\nfunction even(n)",
+		"\nfunction Counter.Even(c,n)",
+	} {
+		if !strings.Contains(w.Content, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestDeclOrder(t *testing.T) {
+	a := `
+	package game
+
+	var Players = newRoster(MaxPlayers)
+
+	func init() {
+		Players.Add("host")
+	}
+	`
+	b := `
+	package game
+
+	const MaxPlayers = 8
+
+	type Roster struct {
+		names []string
+	}
+
+	func (r *Roster) Add(name string) {
+		r.names = append(r.names, name)
+	}
+
+	func newRoster(n int) *Roster {
+		return &Roster{}
+	}
+	`
+
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	for i, src := range []string{a, b} {
+		f, err := Parse(fset, fmt.Sprintf("%d.go", i), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	src, err := Files(fset, "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := luau.NewFile("game", "")
+	file.Decls = append(file.Decls, src...)
+
+	w := luau.NewStringWriter()
+	file.Render(w)
+	fmt.Println(w.Content)
+
+	// each declaration follows the ones it depends on
+	before := [][2]string{
+		{"local Roster = {}", "local function newRoster(n)"},
+		{"local function newRoster(n)", "local Players = newRoster(MaxPlayers)"},
+		{"local MaxPlayers = 8", "local Players = newRoster(MaxPlayers)"},
+		{"local Players = newRoster(MaxPlayers)", "local function init()"},
+		{"function Roster.Add(r,name)", "local function init()"},
+	}
+	for _, pair := range before {
+		i, j := strings.Index(w.Content, pair[0]), strings.Index(w.Content, pair[1])
+		if i < 0 || j < 0 || i > j {
+			t.Errorf("expected %q before %q", pair[0], pair[1])
+		}
+	}
+}
+
+func TestExports(t *testing.T) {
+	text := `
+	package config
+
+	const Version = 2
+
+	var Debug = false
+	var Level = 1
+	var secret = "x"
+
+	type Settings struct{}
+
+	func (s *Settings) Apply() {}
+
+	func NewSettings() *Settings {
+		p := &Level
+		*p = 2
+		return &Settings{}
+	}
+
+	func helper() {}
+	`
+
+	out := render(t, "config.go", text)
+
+	for _, want := range []string{
+		"NewSettings = NewSettings,",
+		"Settings = Settings,",
+		"Version = Version\n",
+		"if key == \"Debug\" then\n\t\t\treturn Debug\n",
+		"elseif key == \"Level\" then\n\t\t\treturn Level.v\n",
+		"Level.v = value",
+		"error(\"cannot assign to \" .. tostring(key),2)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+
+	for _, unwanted := range []string{"secret = secret", "helper = helper", "Apply = Apply"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected %q", unwanted)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"fmt"
+		str "strings"
+		_ "embed"
+		. "math"
+		"math/rand/v2"
+		"os"
+
+		"github.com/intervinn/abq/transform"
+	)
+
+	var _ = transform.Mod[any]("local raw = true")
+
+	func main() {
+		fmt.Println(str.ToUpper("a"), Pi, rand.IntN(5))
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local fmt = GO.import(\"fmt\")",
+		"local str = GO.import(\"strings\")",
+		"GO.import(\"embed\")\n",
+		"local __math = GO.import(\"math\")",
+		"local rand = GO.import(\"math/rand/v2\")",
+		"GO.import(\"os\")\n",
+		"fmt.Println(str.ToUpper(\"a\"),3.141592653589793,rand.IntN(5))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+
+	if strings.Contains(out, "abq/transform") {
+		t.Error("transform package must not be imported")
+	}
+
+	for path, name := range map[string]string{
+		"github.com/foo/go-bar/v2": "bar",
+		"gopkg.in/yaml.v3":         "yaml",
+		"example.com/lib-go":       "lib",
+	} {
+		if got := guessName(path); got != name {
+			t.Errorf("guessName(%q) = %q, want %q", path, got, name)
+		}
+	}
+}
+
+func TestModuleImports(t *testing.T) {
+	// modules missing from the cache mustn't be looked up
+	t.Setenv("GOPROXY", "off")
+
+	text := `
+	package main
+
+	import (
+		"github.com/foo/bar/v2"
+		"github.com/intervinn/abq/luau"
+	)
+
+	func main() {
+		w := luau.NewStringWriter()
+		bar.Do(w)
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local luau = GO.import(\"github.com/intervinn/abq/luau\")",
+		"local w = luau.NewStringWriter()",
+		"local bar = GO.import(\"github.com/foo/bar/v2\")",
+		"bar.Do(w)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestImportScope(t *testing.T) {
+	a := `
+	package main
+
+	import "math/rand"
+
+	func roll() int {
+		return rand.Intn(6)
+	}
+	`
+	b := `
+	package main
+
+	import (
+		"fmt"
+		rand "strings"
+	)
+
+	func shout(s string) string {
+		fmt.Println(s)
+		return rand.ToUpper(s)
+	}
+	`
+
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	for i, src := range []string{a, b} {
+		f, err := Parse(fset, fmt.Sprintf("%d.go", i), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	src, err := Files(fset, "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := luau.NewStringWriter()
+	for _, s := range src {
+		s.Render(w)
+	}
+	fmt.Println(w.Content)
+
+	for _, want := range []string{
+		"local __math_rand = GO.import(\"math/rand\")",
+		"local __strings = GO.import(\"strings\")",
+		"local fmt = GO.import(\"fmt\")",
+		"return __math_rand.Intn(6)",
+		"return __strings.ToUpper(s)",
+	} {
+		if !strings.Contains(w.Content, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestDescriptor(t *testing.T) {
+	text := `
+	package main
+
+	type Point struct {
+		X, Y int
+		end  bool
+		Tags []string ` + "`json:\"tags,omitempty\"`" + `
+	}
+
+	type Celsius float64
+
+	func freezing() any {
+		return Celsius(0)
+	}
+
+	type Kelvin float64
+
+	func (k Kelvin) String() string {
+		return "K"
+	}
+
+	func (k *Kelvin) Heat() {
+		*k++
+	}
+
+	func warm() interface{ String() string } {
+		k := Kelvin(0)
+		p := &k
+		p.Heat()
+		return p
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"Point.__type = {\n\tname = \"Point\",\n\tpkg = \"main\",\n\tpath = \"main\",\n\tkind = \"struct\",\n\tfields = {",
+		"name = \"X\",\n\t\tkey = \"X\",\n\t\ttype = {\n\t\t\tkind = \"int\"\n\t\t}",
+		"name = \"end\",\n\t\tkey = \"end_\"",
+		"tag = \"json:\\\"tags,omitempty\\\"\"",
+		"kind = \"slice\",\n\t\t\telem = {\n\t\t\t\tkind = \"string\"",
+		"zero = function()\n\t\treturn setmetatable({",
+		"Celsius.__type = {\n\tname = \"Celsius\",\n\tpkg = \"main\",\n\tpath = \"main\",\n\tkind = \"float64\",\n\tunderlying = {",
+		"methods = {}\n}\nGO.register(Celsius)",
+		"return GO.box(0,Celsius)",
+		"methods = {\"String\", \"Heat\"},\n\tptrmethods = {\"Heat\"}\n}",
+		"k = GO.pointer(k,Kelvin)",
+		"Kelvin.Heat(p)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestForeignDescriptor(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"os"
+
+		"github.com/intervinn/abq/luau"
+	)
+
+	type Node struct {
+		Name luau.Ident
+		Ret  *luau.ReturnStmt
+		List []luau.Node
+		Mode os.FileMode
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	// field types of other packages keep their descriptors,
+	// through the registry when this file can't name them
+	for _, want := range []string{
+		"name = \"Name\",\n\t\tkey = \"Name\",\n\t\ttype = luau.Ident",
+		"kind = \"ptr\",\n\t\t\telem = luau.ReturnStmt",
+		"kind = \"slice\",\n\t\t\telem = luau.Node",
+		"type = GO.types[\"io/fs.FileMode\"]",
+		"Name = setmetatable({\n\t\t\t\tName = \"\"\n\t\t\t},luau.Ident)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestRunes(t *testing.T) {
+	text := `
+	package main
+
+	func main() {
+		a := 'a'
+		nl := '\n'
+		e := 'é'
+		u := '\u00e9'
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{"local a = 97", "local nl = 10", "local e = 233", "local u = 233"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestStdlib(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"math"
+		"math/bits"
+		"math/rand"
+	)
+
+	func main() {
+		x := math.Floor(math.Sqrt(2) * math.Pi)
+		m := math.MaxInt32
+		z := bits.LeadingZeros32(uint32(m))
+		r := rand.New(rand.NewSource(42))
+		n := r.Intn(10) + rand.Intn(5)
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local rand = GO.import(\"math/rand\")",
+		"local x = math.floor(math.sqrt(2) * 3.141592653589793)",
+		"local m = 2147483647",
+		"bit32.countlz(",
+		"rand.Rand.Intn(r,10) + rand.Intn(5)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+
+	// every member used is mapped onto Luau
+	for _, unwanted := range []string{"GO.import(\"math\")", "GO.import(\"math/bits\")"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected %q", unwanted)
+		}
+	}
+
+	out = render(t, "main.go", `
+	package main
+
+	import "math"
+
+	func main() {
+		inf := math.Inf(1)
+	}
+	`)
+	if !strings.Contains(out, "local math_ = GO.import(\"math\")") || !strings.Contains(out, "math_.Inf(1)") {
+		t.Errorf("unmapped members must come from the shim:\n%s", out)
+	}
+}
+
+func TestStdPaths(t *testing.T) {
+	for path, want := range map[string]bool{
+		"fmt":                true,
+		"encoding/json":      true,
+		"math/rand":          true,
+		"main":               false,
+		"util":               false,
+		"mygame/util":        false,
+		"github.com/foo/bar": false,
+		"./util":             false,
+		"":                   false,
+	} {
+		if got := isStd(path); got != want {
+			t.Errorf("isStd(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestStringer(t *testing.T) {
+	text := `
+	package main
+
+	import "fmt"
+
+	type Color int
+
+	func (c Color) String() string {
+		if c == 0 {
+			return "red"
+		}
+		return "blue"
+	}
+
+	func main() {
+		c := Color(1)
+		fmt.Println(c, c.String())
+
+		d := Color(0)
+		p := &d
+		fmt.Println(p.String())
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"function Color.String(c)",
+		"methods = {\"String\"}",
+		"fmt.Println(GO.box(c,Color),Color.String(c))",
+		"fmt.Println(Color.String(p.v))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestFmtFloats(t *testing.T) {
+	text := `
+	package main
+
+	import "fmt"
+
+	func main() {
+		var x float32 = 2
+		n := 3
+		fmt.Printf("%T %T %T\n", 1.0, x, n)
+		s := fmt.Sprint(x / 2)
+		fmt.Println(s)
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	// unnamed floats carry their type into fmt, ints and strings don't need to
+	for _, want := range []string{
+		"fmt.Printf(\"%T %T %T\\n\",GO.box(1.0,{\n\t\tkind = \"float64\"\n\t}),GO.box(x,{\n\t\tkind = \"float32\"\n\t}),n)",
+		"local s = fmt.Sprint(GO.box(x / 2,{",
+		"fmt.Println(s)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestSlices(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"maps"
+		"slices"
+		"sort"
+	)
+
+	func sum(xs ...int) int {
+		n := 0
+		for _, x := range xs {
+			n += x
+		}
+		return n
+	}
+
+	func numbered(yield func(int, string) bool) {
+		for i := range 3 {
+			if !yield(i, "x") {
+				return
+			}
+		}
+	}
+
+	func find(m map[string]int) (string, int) {
+		for k := range maps.Keys(m) {
+			for i, v := range numbered {
+				if v == k {
+					return k, i
+				}
+			}
+		}
+		return "", -1
+	}
+
+	func main() {
+		s := []int{3, 1, 2}
+		a := [4]int{1: 5}
+		s = append(s, 4)
+		s = append(s, a[:2]...)
+		sort.Ints(s)
+		i := slices.Index(s, 2)
+		n := sum(s...) + len(s) + cap(a)
+		m := map[string]int{}
+		m["x"]++
+		v, ok := m["y"]
+		for k := range maps.Keys(m) {
+			println(k)
+		}
+		for i := range 3 {
+			println(i, s[i], m["z"])
+		}
+		b := []byte("hi")
+		str := string(b[1:])
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local function sum(...)",
+		"local xs = GO.variadic(...)",
+		"local s = GO.newslice({3, 1, 2},3)",
+		"local a = GO.newslice({0, 5, 0, 0},4)",
+		"s = GO.append(s,4)",
+		"s = GO.appendslice(s,GO.slice(a,nil,2,nil))",
+		"sort.Ints(s)",
+		"local i = slices.Index(s,2)",
+		"sum(GO.unpack(s)) + GO.len(s) + 4",
+		"m[\"x\"] = (m and m[\"x\"] or 0) + 1",
+		"local v,ok = GO.lookup(m,\"y\",0)",
+		"GO.iter(maps.Keys(m),function(k)\n\t\tprintln(k)\n\tend)",
+		"local __ret = GO.iter(maps.Keys(m),function(k)",
+		"local __ret = GO.iter(numbered,function(i,v)",
+		"if v == k then\n\t\t\t\t\t\treturn true,k,i",
+		"if __ret then\n\t\t\t\t\treturn true,table.unpack(__ret,2,__ret.n)",
+		"if __ret then\n\t\t\treturn table.unpack(__ret,2,__ret.n)",
+		"return \"\",-1",
+		"for i = 0,3 - 1,1 do",
+		"println(i,s[i],(m and m[\"z\"] or 0))",
+		"local b = GO.bytes(\"hi\")",
+		"local str = GO.bytestring(GO.slice(b,1,nil,nil))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"errors"
+		"fmt"
+	)
+
+	type NotFound struct {
+		Name string
+	}
+
+	func (e *NotFound) Error() string {
+		return e.Name + " not found"
+	}
+
+	type Code int
+
+	func (c Code) Error() string {
+		return "code"
+	}
+
+	var ErrClosed = errors.New("closed")
+
+	func find(name string) error {
+		return fmt.Errorf("find: %w", &NotFound{Name: name})
+	}
+
+	func main() {
+		err := find("x")
+		var nf *NotFound
+		if errors.As(err, &nf) {
+			println(nf.Name)
+		}
+		var timeout interface{ Timeout() bool }
+		ok := errors.As(err, &timeout)
+		println(errors.Is(err, ErrClosed), err.Error(), ok)
+
+		var coded error = Code(404)
+		println(coded.Error(), errors.Is(coded, Code(404)))
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local ErrClosed = errors.New(\"closed\")",
+		"errors.As(err,nf,NotFound.__type)",
+		"kind = \"interface\",",
+		"methods = {\"Timeout\"}",
+		"errors.Is(err,ErrClosed)",
+		"function Code.Error(c)",
+		"local coded = GO.box(404,Code)",
+		"coded.Error(coded)",
+		"errors.Is(coded,GO.box(404,Code))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	text := `
+	package main
+
+	import "time"
+
+	func worker(jobs chan int, done chan bool) {
+		for j := range jobs {
+			println(j)
+		}
+		done <- true
+	}
+
+	func main() {
+		jobs := make(chan int, 3)
+		done := make(chan bool)
+		go worker(jobs, done)
+		jobs <- 1
+		close(jobs)
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		timeout := time.After(2 * time.Second)
+		start := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				println(time.Since(start).Seconds())
+			case ok := <-done:
+				println(ok)
+				return
+			case <-timeout:
+				return
+			}
+		}
+		v, ok := <-jobs
+		time.Sleep(time.Second)
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"for _,j in GO.chaniter(jobs) do",
+		"GO.send(done,true)",
+		"local jobs = GO.makechan(3)",
+		"local done = GO.makechan()",
+		"GO.go(worker,jobs,done)",
+		"GO.close(jobs)",
+		"local ticker = time.NewTicker(100000000)",
+		"local __case,__recv,__recvok = GO.select({",
+		"if __case == 1 then",
+		"println(time.Duration.Seconds(time.Since(start)))",
+		"elseif __case == 2 then",
+		"local ok = __recv",
+		"local v,ok = GO.recv(jobs,0)",
+		"time.Sleep(1000000000)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"sync"
+		"sync/atomic"
+	)
+
+	type Counter struct {
+		mu sync.Mutex
+		n  map[string]int
+	}
+
+	func (c *Counter) Inc(key string) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.n[key]++
+	}
+
+	func main() {
+		var wg sync.WaitGroup
+		var once sync.Once
+		var hits atomic.Int64
+		var total int32
+		c := &Counter{n: map[string]int{}}
+		for i := range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				once.Do(func() { println("first") })
+				c.Inc("a")
+				hits.Add(1)
+				atomic.AddInt32(&total, int32(i))
+			}()
+		}
+		wg.Wait()
+		println(hits.Load(), atomic.LoadInt32(&total))
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local sync = GO.import(\"sync\")",
+		"local atomic = GO.import(\"sync/atomic\")",
+		"sync.Mutex.Lock(c.mu)",
+		"local wg = setmetatable({},sync.WaitGroup)",
+		"mu = setmetatable({},sync.Mutex),",
+		"sync.WaitGroup.Add(wg,1)",
+		"sync.Once.Do(once,function()",
+		"atomic.Int64.Add(hits,1)",
+		"atomic.AddInt32(total,((i + 2^31) % 2^32 - 2^31))",
+		"sync.WaitGroup.Wait(wg)",
+		"atomic.LoadInt32(total)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	text := `
+	package main
+
+	import "sync"
+
+	type Base struct {
+		ID int
+	}
+
+	func (b *Base) Describe() int {
+		return b.ID
+	}
+
+	type Named interface {
+		Name() string
+	}
+
+	type Counter struct {
+		sync.Mutex
+		*Base
+		Named
+		n int
+	}
+
+	func main() {
+		c := &Counter{Base: &Base{ID: 1}}
+		c.Lock()
+		c.n++
+		c.Unlock()
+		c.ID = 2
+		println(c.ID, c.Describe(), c.Name())
+
+		var l sync.Locker = c
+		l.Lock()
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"sync.Mutex.Lock(c.Mutex)",
+		"sync.Mutex.Unlock(c.Mutex)",
+		"c.Base.ID = 2",
+		"println(c.Base.ID,Base.Describe(c.Base),c.Named.Name(c.Named))",
+		"methods = {\"Describe\", \"Lock\", \"Name\", \"TryLock\", \"Unlock\"}",
+		"function Counter.Lock(self,...)\n\treturn sync.Mutex.Lock(self.Mutex,...)\nend",
+		"function Counter.Name(self,...)\n\treturn self.Named.Name(self.Named,...)\nend",
+		"l.Lock(l)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"context"
+		"time"
+	)
+
+	type key string
+
+	func round(ctx context.Context, ticks chan int) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case n := <-ticks:
+				println(n, ctx.Value(key("round")))
+			}
+		}
+	}
+
+	func main() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ctx = context.WithValue(ctx, key("round"), 1)
+		ctx, stop := context.WithTimeout(ctx, 5*time.Second)
+		defer stop()
+		go round(ctx, make(chan int))
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local context = GO.import(\"context\")",
+		"ch = ctx.Done(ctx)",
+		"return ctx.Err(ctx)",
+		"local ctx,cancel = context.WithCancel(context.Background())",
+		"ctx = context.WithValue(ctx,GO.box(\"round\",key),1)",
+		"context.WithTimeout(ctx,5000000000)",
+		"GO.defer(__defers,stop)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	text := `
+	package main
+
+	import "encoding/json"
+
+	type Player struct {
+		Name  string         ` + "`json:\"name\"`" + `
+		Score int            ` + "`json:\"score,omitempty\"`" + `
+		Items []string       ` + "`json:\"items\"`" + `
+		Stats map[string]int ` + "`json:\"-\"`" + `
+	}
+
+	func main() {
+		b, err := json.Marshal(Player{Name: "a"})
+		var p Player
+		err = json.Unmarshal(b, &p)
+		var scores []int
+		err = json.Unmarshal([]byte("[1, 2]"), &scores)
+		out, err := json.MarshalIndent(p, "", "  ")
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local json = GO.import(\"encoding/json\")",
+		"tag = \"json:\\\"score,omitempty\\\"\"",
+		"},Player)",
+		"local b,err = json.Marshal(GO.box(setmetatable({",
+		"err = json.Unmarshal(b,p,{\n\t\tkind = \"ptr\",\n\t\telem = Player\n\t})",
+		"kind = \"slice\",\n\t\t\telem = {\n\t\t\t\tkind = \"int\"",
+		"json.MarshalIndent(GO.box(p,Player),\"\",\"  \",Player)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	text := `
+	package main
+
+	import (
+		"log"
+		"strings"
+	)
+
+	func main() {
+		log.Printf("round %d", 3)
+		var b strings.Builder
+		l := log.New(&b, "match: ", log.LstdFlags|log.Lmsgprefix)
+		l.Println("started")
+		if b.Len() == 0 {
+			log.Fatal("nothing logged")
+		}
+		log.Panicf("bad state %v", b.String())
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local log = GO.import(\"log\")",
+		"log.Printf(\"round %d\",3)",
+		"local l = log.New(b,\"match: \",67)",
+		"log.Logger.Println(l,\"started\")",
+		"log.Fatal(\"nothing logged\")",
+		"log.Panicf(\"bad state %v\",strings.Builder.String(b))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestReflect(t *testing.T) {
+	text := `
+	package main
+
+	import "reflect"
+
+	type Config struct {
+		Port int    ` + "`env:\"PORT\"`" + `
+		Host string
+	}
+
+	func main() {
+		var c Config
+		v := reflect.ValueOf(&c).Elem()
+		typ := v.Type()
+		for i := range v.NumField() {
+			if tag := typ.Field(i).Tag.Get("env"); tag != "" && v.Field(i).Kind() == reflect.Int {
+				v.Field(i).SetInt(8080)
+			}
+		}
+		name := reflect.TypeOf(c).Name()
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local reflect = GO.import(\"reflect\")",
+		"tag = \"env:\\\"PORT\\\"\"",
+		"reflect.Value.Elem(reflect.ValueOf(c,{\n\t\tkind = \"ptr\",\n\t\telem = Config\n\t}))",
+		"local typ = reflect.Value.Type(v)",
+		"reflect.StructTag.Get(typ.Field(typ,i).Tag,\"env\")",
+		"reflect.Value.Kind(reflect.Value.Field(v,i)) == 2",
+		"reflect.Value.SetInt(reflect.Value.Field(v,i),8080)",
+		"reflect.TypeOf(GO.box(c,Config),Config)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestEquality(t *testing.T) {
+	text := `
+	package main
+
+	type Point struct {
+		X, Y int
+	}
+
+	type Code int
+
+	func main() {
+		p, q := Point{1, 2}, &Point{1, 2}
+		same := p == *q
+		grid := [2]Point{}
+		diff := grid != [2]Point{p, p}
+		var a, b any = p, Point{1, 2}
+		eq := a == b
+		var code any = Code(1)
+		isCode := code == Code(1)
+		isNil := code == nil
+		ptr := q == &p
+		mixed := a == p
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"local same = GO.equal(p,q,Point)",
+		"local diff = not GO.equal(grid,GO.newslice({p, p},2),{\n\t\tkind = \"array\",\n\t\tlen = 2,\n\t\telem = Point\n\t})",
+		"local a,b = GO.box(p,Point),GO.box(setmetatable({",
+		"local eq = GO.equal(a,b)",
+		"local isCode = code == GO.box(1,Code)",
+		"local isNil = code == nil",
+		"local ptr = q == p",
+		"local mixed = GO.equal(a,GO.box(p,Point))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestMapKeys(t *testing.T) {
+	text := `
+	package main
+
+	type Vec2i struct {
+		X, Y int
+	}
+
+	type Tile int
+
+	func main() {
+		grid := map[Vec2i]Tile{{0, 0}: 1}
+		grid[Vec2i{1, 2}] = 2
+		tile, ok := grid[Vec2i{1, 2}]
+		delete(grid, Vec2i{0, 0})
+		names := map[string]int{"a": 1}
+		names["b"] = 2
+		seen := map[any]bool{}
+		seen[Vec2i{}] = true
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"[GO.key(setmetatable({\n\t\t\tX = 0,\n\t\t\tY = 0\n\t\t},Vec2i),Vec2i)] = 1",
+		"grid[GO.key(setmetatable({\n\t\tX = 1,\n\t\tY = 2\n\t},Vec2i),Vec2i)] = 2",
+		"local tile,ok = GO.lookup(grid,GO.key(setmetatable({",
+		"GO.delete(grid,GO.key(setmetatable({",
+		"names[\"b\"] = 2",
+		"seen[GO.key(GO.box(setmetatable({",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestNil(t *testing.T) {
+	text := `
+	package main
+
+	type NotFound struct {
+		Name string
+	}
+
+	func (e *NotFound) Error() string { return e.Name }
+
+	func find(name string) error {
+		var p *NotFound
+		return p
+	}
+
+	func main() {
+		var xs []int
+		empty := len(xs) == 0 && xs == nil
+		xs = append(xs, 1)
+		for _, x := range xs {
+			println(x)
+		}
+		var m map[string]int
+		n := m["a"]
+		err := find("a")
+		println(err != nil, empty, n)
+		var fn func()
+		var v any = fn
+		var h func(int, ...string) error
+		var w any = h
+		var e error = &NotFound{}
+	}
+	`
+
+	out := render(t, "main.go", text)
+
+	for _, want := range []string{
+		"return (p or GO.typednil({\n\t\tkind = \"ptr\",\n\t\telem = NotFound\n\t}))",
+		"local empty = GO.len(xs) == 0 and xs == nil",
+		"xs = GO.append(xs,1)",
+		"for _,x in xs or {} do",
+		"local n = (m and m[\"a\"] or 0)",
+		"println(err ~= nil,empty,n)",
+		"local v = (fn or GO.typednil({\n\t\tkind = \"func\",\n\t\tparams = {},\n\t\tresults = {}\n\t}))",
+		"local w = (h or GO.typednil({\n\t\tkind = \"func\",\n\t\tparams = {{\n\t\t\tkind = \"int\"\n\t\t}, {\n\t\t\tkind = \"slice\",",
+		"results = {{\n\t\t\tkind = \"interface\",\n\t\t\tmethods = {\"Error\"}\n\t\t}},\n\t\tvariadic = true\n\t}))",
+		"local e = setmetatable({",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package transform
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/intervinn/abq/luau"
+)
+
+// Package holds the type information shared by the files of a Go package
+type Package struct {
+	Fset   *token.FileSet
+	Files  []*ast.File
+	Types  *types.Package
+	Info   *types.Info
+	Errors []error // type errors, the transformation goes on regardless
+
+	boxed    map[types.Object]bool // variables which have their address taken
+	usedPkgs map[*types.PkgName]bool
+	// packages referred to through members mapped onto Luau, see stdMember
+	mappedPkgs map[*types.PkgName]bool
+	imports    map[string]string // locals holding the imported modules by path
+}
+
+// NewPackage type checks the files of a package,
+// path is its import path or empty to go by the package name
+func NewPackage(fset *token.FileSet, path string, files []*ast.File) *Package {
+	p := &Package{
+		Fset:  fset,
+		Files: files,
+		Info: &types.Info{
+			Types:      map[ast.Expr]types.TypeAndValue{},
+			Defs:       map[*ast.Ident]types.Object{},
+			Uses:       map[*ast.Ident]types.Object{},
+			Implicits:  map[ast.Node]types.Object{},
+			Selections: map[*ast.SelectorExpr]*types.Selection{},
+		},
+		Errors:     []error{},
+		boxed:      map[types.Object]bool{},
+		usedPkgs:   map[*types.PkgName]bool{},
+		mappedPkgs: map[*types.PkgName]bool{},
+		imports:    map[string]string{},
+	}
+
+	conf := types.Config{
+		Importer: newModuleImporter(packageDir(fset, files)),
+		Error: func(err error) {
+			p.Errors = append(p.Errors, err)
+		},
+	}
+
+	if path == "" && len(files) > 0 {
+		path = files[0].Name.Name
+	}
+	p.Types, _ = conf.Check(path, fset, files, p.Info)
+	p.nameImports()
+
+	for _, f := range files {
+		p.escapes(f)
+	}
+	// packages only referred to through members mapped onto Luau aren't imported
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			s, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			id, ok := s.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if pn, ok := p.Info.Uses[id].(*types.PkgName); ok {
+				if _, mapped := stdMember(p.Info.Uses[s.Sel]); mapped {
+					p.mappedPkgs[pn] = true
+				} else {
+					p.usedPkgs[pn] = true
+				}
+			} else if pn := p.unresolvedImport(f, id); pn != nil {
+				p.usedPkgs[pn] = true
+			}
+			return true
+		})
+	}
+	return p
+}
+
+// type of an expression, nil if unknown
+func (f *File) typeOf(e ast.Expr) types.Type {
+	t := f.Pkg.Info.TypeOf(e)
+	if t == nil {
+		return nil
+	}
+	if b, ok := t.(*types.Basic); ok && b.Kind() == types.Invalid {
+		return nil
+	}
+	return t
+}
+
+func (f *File) objectOf(i *ast.Ident) types.Object {
+	return f.Pkg.Info.ObjectOf(i)
+}
+
+// structs and arrays are tables, so pointers to them are the tables themselves
+func isAggregate(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Underlying().(type) {
+	case *types.Struct, *types.Array:
+		return true
+	}
+	return false
+}
+
+func isString(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsString != 0
+}
+
+func isNumeric(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsNumeric != 0
+}
+
+func isInteger(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsInteger != 0
+}
+
+// underlying type, nil for untyped expressions
+func underlying(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	return t.Underlying()
+}
+
+// pointed type, nil if t is not a pointer
+func elem(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return nil
+}
+
+// zero value of a type
+func zero(t types.Type, f *File) luau.Node {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsNumeric != 0:
+			return &luau.NumericLit{Value: "0"}
+		case u.Info()&types.IsString != 0:
+			return &luau.StringLit{Value: ""}
+		case u.Info()&types.IsBoolean != 0:
+			return &luau.Ident{Name: "false"}
+		}
+	case *types.Struct:
+		elts := []luau.Node{}
+		for i := range u.NumFields() {
+			field := u.Field(i)
+			if field.Name() == "_" {
+				continue
+			}
+			// unexported fields of other packages belong to their runtime shims
+			if !field.Exported() && field.Pkg() != f.Pkg.Types {
+				continue
+			}
+			elts = append(elts, &luau.KeyValueExpr{
+				Key:   &luau.Ident{Name: Mangle(field.Name())},
+				Value: zero(field.Type(), f),
+			})
+		}
+		return instance(t, &luau.TableLit{Elts: elts}, f)
+	case *types.Array:
+		// every struct element needs its own table
+		if isAggregate(u.Elem()) {
+			elts := make([]luau.Node, u.Len())
+			for i := range elts {
+				elts[i] = zero(u.Elem(), f)
+			}
+			return newSlice(&luau.TableLit{Elts: elts}, u.Len())
+		}
+
+		return newSlice(&luau.CallExpr{
+			Fun: &luau.SelectorExpr{
+				X:   &luau.Ident{Name: "table"},
+				Sel: &luau.Ident{Name: "create"},
+			},
+			Args: []luau.Node{
+				&luau.NumericLit{Value: strconv.FormatInt(u.Len(), 10)},
+				zero(u.Elem(), f),
+			},
+		}, u.Len())
+	}
+	return &luau.Ident{Name: "nil"}
+}
+
+func isNil(n luau.Node) bool {
+	id, ok := n.(*luau.Ident)
+	return ok && id.Name == "nil"
+}
+
+// instance of a struct type, named types get their metatable
+// ex: setmetatable({X = 0}, Point)
+func instance(t types.Type, lit *luau.TableLit, f *File) luau.Node {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return lit
+	}
+
+	return &luau.CallExpr{
+		Fun:  &luau.Ident{Name: "setmetatable"},
+		Args: []luau.Node{lit, typeName(named, f)},
+	}
+}
+
+// expression referring to the table of a named type
+func typeName(n *types.Named, f *File) luau.Node {
+	pkg := n.Obj().Pkg()
+	if pkg == nil || pkg == f.Pkg.Types {
+		return &luau.Ident{Name: Mangle(n.Obj().Name())}
+	}
+	return importedName(pkg, n.Obj().Name(), f)
+}